@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupIndexFilename is the name of the index file of a backup generation.
+const backupIndexFilename = "index.json"
+
+// backupFilesDir is the directory inside a generation where backed up files are stored.
+const backupFilesDir = "files"
+
+// backupIDLayout is the time layout of backup generation IDs.
+const backupIDLayout = "20060102T150405Z"
+
+// BackupGeneration is a set of backups made during a single dotbro run.
+// Each generation lives in its own directory under the backup directory,
+// so a backup never overwrites another one.
+type BackupGeneration struct {
+	// ID identifies the generation. It is also the name of its directory.
+	ID string `json:"id"`
+
+	// Time is the moment when the generation was started.
+	Time time.Time `json:"time"`
+
+	// Entries are the files backed up in this generation.
+	Entries []BackupEntry `json:"entries"`
+
+	// dir is the directory of the generation.
+	dir string
+}
+
+// BackupEntry represents a single backed up file.
+type BackupEntry struct {
+	// Original is the path where the file was located.
+	Original string `json:"original"`
+
+	// Path is the path of the backup relative to the generation directory.
+	Path string `json:"path"`
+}
+
+// NewBackupGeneration returns a new generation in the backup directory.
+// The generation ID is based on the given time and never clashes with an
+// existing generation.
+func NewBackupGeneration(backupDir string, now time.Time) *BackupGeneration {
+	now = now.UTC()
+	base := now.Format(backupIDLayout)
+	id := base
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(backupDir, id)); os.IsNotExist(err) {
+			break
+		}
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+
+	return &BackupGeneration{
+		ID:   id,
+		Time: now,
+		dir:  filepath.Join(backupDir, id),
+	}
+}
+
+// LoadBackupGenerations reads indexes of all generations in the backup directory.
+// Generations are sorted from the oldest to the newest.
+// Directories without an index are skipped.
+func LoadBackupGenerations(backupDir string) ([]*BackupGeneration, error) {
+	entries, err := os.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []*BackupGeneration
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(backupDir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, backupIndexFilename))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		g := &BackupGeneration{dir: dir}
+		if err = json.Unmarshal(data, g); err != nil {
+			return nil, fmt.Errorf("parse backup index of %s: %w", entry.Name(), err)
+		}
+		generations = append(generations, g)
+	}
+
+	sort.Slice(generations, func(i, j int) bool {
+		if generations[i].Time.Equal(generations[j].Time) {
+			return generations[i].ID < generations[j].ID
+		}
+		return generations[i].Time.Before(generations[j].Time)
+	})
+
+	return generations, nil
+}
+
+// Dir returns the directory of the generation.
+func (g *BackupGeneration) Dir() string {
+	return g.dir
+}
+
+// PathFor returns the backup path for the original file.
+// The path keeps the location of the original file relative to destDir,
+// or its full path if the file is located outside of destDir.
+func (g *BackupGeneration) PathFor(original, destDir string) string {
+	rel := strings.TrimPrefix(original, "/")
+	if IsInside(destDir, original) {
+		rel, _ = filepath.Rel(destDir, original)
+	}
+	return filepath.Join(g.dir, backupFilesDir, rel)
+}
+
+// Add records a backup of the original file stored at backup path.
+func (g *BackupGeneration) Add(original, backup string) {
+	rel, err := filepath.Rel(g.dir, backup)
+	if err != nil {
+		rel = backup
+	}
+	g.Entries = append(g.Entries, BackupEntry{Original: original, Path: rel})
+}
+
+// Find returns the backup path of the original file in this generation.
+func (g *BackupGeneration) Find(original string) (string, bool) {
+	for _, entry := range g.Entries {
+		if entry.Original == original {
+			return g.BackupPath(entry), true
+		}
+	}
+	return "", false
+}
+
+// BackupPath returns the absolute path of the entry backup.
+func (g *BackupGeneration) BackupPath(entry BackupEntry) string {
+	return filepath.Join(g.dir, entry.Path)
+}
+
+// Save atomically writes the index file of the generation.
+// Nothing is written for a generation without entries.
+func (g *BackupGeneration) Save() error {
+	if len(g.Entries) == 0 {
+		return nil
+	}
+
+	if err := osfs.MkdirAll(g.dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(g, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(filepath.Join(g.dir, backupIndexFilename), data, 0600)
+}
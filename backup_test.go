@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBackupGeneration_UniqueID(t *testing.T) {
+	t.Parallel()
+
+	backupDir := t.TempDir()
+	now := time.Date(2016, 9, 23, 12, 30, 0, 0, time.UTC)
+
+	first := NewBackupGeneration(backupDir, now)
+	assert.Equal(t, "20160923T123000Z", first.ID)
+	require.NoError(t, os.MkdirAll(first.Dir(), 0700))
+
+	second := NewBackupGeneration(backupDir, now)
+	assert.Equal(t, "20160923T123000Z-2", second.ID)
+}
+
+func TestBackupGeneration_PathFor(t *testing.T) {
+	t.Parallel()
+
+	g := NewBackupGeneration("/backup", time.Date(2016, 9, 23, 12, 30, 0, 0, time.UTC))
+
+	assert.Equal(t,
+		"/backup/20160923T123000Z/files/.config/nvim/init.lua",
+		g.PathFor("/home/user/.config/nvim/init.lua", "/home/user"))
+	assert.Equal(t,
+		"/backup/20160923T123000Z/files/etc/hosts",
+		g.PathFor("/etc/hosts", "/home/user"))
+}
+
+func TestBackupGeneration_SaveLoad(t *testing.T) {
+	t.Parallel()
+
+	backupDir := t.TempDir()
+
+	older := NewBackupGeneration(backupDir, time.Date(2016, 9, 23, 12, 30, 0, 0, time.UTC))
+	older.Add("/home/user/.vimrc", older.PathFor("/home/user/.vimrc", "/home/user"))
+	require.NoError(t, older.Save())
+
+	newer := NewBackupGeneration(backupDir, time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer.Add("/home/user/.vimrc", newer.PathFor("/home/user/.vimrc", "/home/user"))
+	require.NoError(t, newer.Save())
+
+	// Generations without entries leave nothing behind.
+	empty := NewBackupGeneration(backupDir, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, empty.Save())
+	assert.NoDirExists(t, empty.Dir())
+
+	// Legacy backups are not generations.
+	require.NoError(t, os.MkdirAll(filepath.Join(backupDir, ".config"), 0700))
+
+	generations, err := LoadBackupGenerations(backupDir)
+	require.NoError(t, err)
+	require.Len(t, generations, 2)
+	assert.Equal(t, older.ID, generations[0].ID)
+	assert.Equal(t, newer.ID, generations[1].ID)
+	assert.Equal(t, newer.Dir(), generations[1].Dir())
+
+	backup, ok := generations[1].Find("/home/user/.vimrc")
+	require.True(t, ok)
+	assert.Equal(t, filepath.Join(newer.Dir(), "files", ".vimrc"), backup)
+
+	_, ok = generations[1].Find("/home/user/.zshrc")
+	assert.False(t, ok)
+}
+
+func TestLoadBackupGenerations_NotExists(t *testing.T) {
+	t.Parallel()
+
+	generations, err := LoadBackupGenerations(filepath.Join(t.TempDir(), "missing"))
+
+	require.NoError(t, err)
+	assert.Empty(t, generations)
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Diagnostic is a problem found in a profile file.
+type Diagnostic struct {
+	// File is the path to the profile file.
+	File string
+
+	// Line is the line number the problem is found at, starting with 1.
+	// It is 0 if the line is unknown.
+	Line int
+
+	// Message describes the problem.
+	Message string
+}
+
+// String returns the diagnostic as "file:line: message".
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return d.File + ": " + d.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// profileKeys lists the keys known in every section of a profile.
+// Keys of [mapping], [variables] and [roots] are arbitrary.
+var profileKeys = map[string][]string{
+	"":            {"include", "directories", "mapping", "files", "install", "variables", "roots"},
+	"directories": {"dotfiles", "sources", "destination", "backup"},
+	"files":       {"excludes"},
+	"install":     {"relative_links", "conflict"},
+}
+
+// CheckProfile validates the loaded profile and the files it includes deeper
+// than NewProfile does, and returns all found problems sorted by file and line.
+func CheckProfile(p *Profile) ([]Diagnostic, error) {
+	c := profileChecker{profile: p}
+	for _, filename := range p.Files() {
+		format, err := ProfileFormatFor(filename)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		c.files = append(c.files, profileFile{name: filename, format: format, content: content})
+	}
+
+	for _, file := range c.files {
+		unknown, err := unknownKeys(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range unknown {
+			c.reportAt(file.name, file.format.Line(file.content, key, ""), "unknown key '%s'", strings.Join(key, "."))
+		}
+	}
+
+	if err := c.checkMapping(); err != nil {
+		return nil, err
+	}
+	if err := c.checkExcludes(); err != nil {
+		return nil, err
+	}
+
+	order := make(map[string]int)
+	for i, file := range c.files {
+		order[file.name] = i
+	}
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i], c.diagnostics[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return c.diagnostics, nil
+}
+
+// profileFile is a loaded profile file.
+type profileFile struct {
+	name    string
+	format  ProfileFormat
+	content []byte
+}
+
+type profileChecker struct {
+	profile     *Profile
+	files       []profileFile
+	diagnostics []Diagnostic
+}
+
+// report adds a diagnostic located at the key path, or at the string value
+// of the key path if value is not empty.
+func (c *profileChecker) report(key []string, value string, format string, args ...any) {
+	file, line := c.locate(key, value)
+	c.reportAt(file, line, format, args...)
+}
+
+// reportAt adds a diagnostic located at the line of the file.
+func (c *profileChecker) reportAt(file string, line int, format string, args ...any) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		File:    file,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// locate returns the file and the line of the key path, or of the string value
+// of the key path. Mapping entries are looked for in the files they come from
+// by their keys as written, everything else in the last file that has it.
+func (c *profileChecker) locate(key []string, value string) (string, int) {
+	origin := ""
+	if len(key) > 1 && key[0] == "mapping" {
+		var raw string
+		if origin, raw = c.profile.Origin(key[1]); raw != "" {
+			key = append([]string{"mapping", raw}, key[2:]...)
+		}
+	}
+
+	for i := len(c.files) - 1; i >= 0; i-- {
+		file := c.files[i]
+		if origin != "" && origin != file.name {
+			continue
+		}
+		if line := file.format.Line(file.content, key, value); line != 0 {
+			return file.name, line
+		}
+	}
+	return c.profile.Filepath(), 0
+}
+
+// unknownKeys returns paths of keys of the file that profiles do not support.
+func unknownKeys(file profileFile) ([][]string, error) {
+	keys, err := file.format.UnknownKeys(file.content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report an unknown table, but not every key in it.
+	var result [][]string
+	for _, key := range keys {
+		if len(result) > 0 && isKeyPrefix(result[len(result)-1], key) {
+			continue
+		}
+		result = append(result, key)
+	}
+	return result, nil
+}
+
+// tomlUnknownKeys returns paths of keys in the TOML content that profiles do not support.
+func tomlUnknownKeys(content []byte) ([][]string, error) {
+	var data ProfileData
+	md, err := toml.Decode(string(content), &data)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][]string
+	for _, key := range md.Undecoded() {
+		// Options of mapping entries are validated on decoding.
+		if len(key) > 0 && key[0] == "mapping" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// jsonUnknownKeys returns paths of keys in the JSON content that profiles do not support.
+func jsonUnknownKeys(content []byte) ([][]string, error) {
+	object, err := jsonObject(content)
+	if err != nil {
+		return nil, err
+	}
+	// Go decodes JSON keys case-insensitively.
+	return unknownProfileKeys(object, nil, strings.EqualFold), nil
+}
+
+// unknownProfileKeys returns paths of keys of the object at prefix that are
+// not listed in profileKeys. equal compares key names.
+func unknownProfileKeys(object map[string]any, prefix []string, equal func(a, b string) bool) [][]string {
+	known, ok := profileKeys[strings.Join(prefix, ".")]
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var keys [][]string
+	for _, name := range names {
+		key := append(append([]string{}, prefix...), name)
+
+		isKnown := false
+		for _, k := range known {
+			isKnown = isKnown || equal(k, name)
+		}
+		if !isKnown {
+			keys = append(keys, key)
+			continue
+		}
+
+		if value, ok := object[name].(map[string]any); ok {
+			keys = append(keys, unknownProfileKeys(value, key, equal)...)
+		}
+	}
+	return keys
+}
+
+// checkMapping reports mapping sources that do not exist, and destinations
+// that are mapped twice, are outside of the destination directory or are
+// inside the dotfiles directory.
+func (c *profileChecker) checkMapping() error {
+	srcDirAbs := path.Join(c.profile.DotfilesDir(), c.profile.SourcesDir())
+	destDir := c.profile.DestinationDir()
+
+	mapping := c.profile.Data().Mapping
+	sources := make([]string, 0, len(mapping))
+	for src := range mapping {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+
+	destinations := make(map[string][]string)
+	for _, src := range sources {
+		entry := mapping[src]
+		key := []string{"mapping", src}
+
+		if IsGlob(src) {
+			files, err := ExpandGlob(srcDirAbs, src)
+			if err != nil {
+				c.report(key, "", "mapping pattern '%s' is invalid: %s", src, err)
+			} else if len(files) == 0 {
+				c.report(key, "", "mapping pattern '%s' matches no files", src)
+			}
+		} else if _, err := os.Lstat(path.Join(srcDirAbs, src)); os.IsNotExist(err) {
+			c.report(key, "", "mapping source '%s' does not exist", src)
+		} else if err != nil {
+			return err
+		}
+
+		destAbs := c.profile.DestinationPath(entry.Destination)
+		switch {
+		case !path.IsAbs(entry.Destination) && !IsInside(destDir, destAbs):
+			c.report(key, "", "destination '%s' of '%s' is outside of the destination directory %s", entry.Destination, src, destDir)
+		case IsInside(c.profile.DotfilesDir(), destAbs):
+			c.report(key, "", "destination '%s' of '%s' is inside the dotfiles directory %s", entry.Destination, src, c.profile.DotfilesDir())
+		}
+
+		for _, other := range destinations[destAbs] {
+			if conditionsOverlap(entry, mapping[other]) {
+				file, line := c.locate([]string{"mapping", other}, "")
+				location := fmt.Sprintf("line %d", line)
+				if origin, _ := c.profile.Origin(src); file != origin {
+					location = fmt.Sprintf("%s:%d", file, line)
+				}
+				c.report(key, "", "destination '%s' of '%s' is already mapped from '%s' (%s)",
+					entry.Destination, src, other, location)
+			}
+		}
+		destinations[destAbs] = append(destinations[destAbs], src)
+	}
+
+	return nil
+}
+
+// conditionsOverlap reports whether both entries can match the same machine.
+// It is so unless some condition is set in both entries to different patterns.
+func conditionsOverlap(a, b MappingEntry) bool {
+	pairs := [][2]string{{a.OS, b.OS}, {a.Hostname, b.Hostname}, {a.Arch, b.Arch}, {a.Env, b.Env}}
+	for _, pair := range pairs {
+		if pair[0] != "" && pair[1] != "" && pair[0] != pair[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkExcludes reports excludes that match no files.
+func (c *profileChecker) checkExcludes() error {
+	excludes := c.profile.Data().Files.Excludes
+	if len(excludes) == 0 {
+		return nil
+	}
+
+	if len(c.profile.Data().Mapping) > 0 {
+		c.report([]string{"files", "excludes"}, "", "excludes are ignored when mapping is specified")
+		return nil
+	}
+
+	srcDirAbs := path.Join(c.profile.DotfilesDir(), c.profile.SourcesDir())
+	for _, exclude := range excludes {
+		_, err := os.Lstat(path.Join(srcDirAbs, exclude))
+		if os.IsNotExist(err) || strings.Contains(exclude, "/") {
+			c.report([]string{"files", "excludes"}, exclude, "exclude '%s' matches no files", exclude)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isKeyPrefix reports whether prefix is a prefix of the key path.
+func isKeyPrefix(prefix, key []string) bool {
+	if len(prefix) > len(key) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != key[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// textLine returns the line number of the key path in the TOML or JSON
+// content, or of the string value of the key path. Every key of the path is
+// looked for after the previous one. It returns 0 if nothing is found.
+func textLine(content []byte, key []string, value string) int {
+	return findLine(string(content), key, value)
+}
+
+func findLine(content string, key []string, value string) int {
+	offset := 0
+	for _, name := range key {
+		i := findString(content[offset:], name, true)
+		if i == -1 {
+			return 0
+		}
+		offset += i
+	}
+	if value != "" {
+		i := findString(content[offset:], value, false)
+		if i == -1 {
+			return 0
+		}
+		offset += i
+	}
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+// findString returns the index of the first spelling of the string s in
+// content, either quoted or bare, or -1. If isKey is set, only the spellings
+// followed by a key-value separator or a table header end are found.
+func findString(content, s string, isKey bool) int {
+	quoted, _ := json.Marshal(s)
+	spellings := []string{string(quoted), tomlQuote(s), "'" + s + "'"}
+	if isKey && tomlBareKey.MatchString(s) {
+		spellings = append(spellings, s)
+	}
+
+	best := -1
+	for _, spelling := range spellings {
+		for from := 0; from < len(content); {
+			i := strings.Index(content[from:], spelling)
+			if i == -1 {
+				break
+			}
+			i += from
+			from = i + 1
+
+			if spelling == s && !isBareKeyAt(content, i, len(s)) || isInComment(content, i) {
+				continue
+			}
+			if isKey && !isKeyEnd(content[i+len(spelling):]) {
+				continue
+			}
+			if best == -1 || i < best {
+				best = i
+			}
+			break
+		}
+	}
+	return best
+}
+
+// isKeyEnd reports whether rest of content starts with a key-value separator,
+// a dot of a dotted key or a table header end.
+func isKeyEnd(rest string) bool {
+	rest = strings.TrimLeft(rest, " \t")
+	return rest != "" && strings.ContainsAny(rest[:1], "=:.]")
+}
+
+// isInComment reports whether index i of content is in a comment line.
+func isInComment(content string, i int) bool {
+	lineStart := strings.LastIndexByte(content[:i], '\n') + 1
+	return strings.HasPrefix(strings.TrimSpace(content[lineStart:i]), "#")
+}
+
+// isBareKeyAt reports whether the bare key of length n at index i of content
+// is a whole word.
+func isBareKeyAt(content string, i, n int) bool {
+	isKeyChar := func(b byte) bool {
+		return b == '_' || b == '-' || b == '.' || b == '"' || b == '\'' ||
+			'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+	}
+	if i > 0 && isKeyChar(content[i-1]) {
+		return false
+	}
+	return i+n >= len(content) || !isKeyChar(content[i+n])
+}
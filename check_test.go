@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckProfile_TOML(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "dotfiles", "vim"), 0700))
+	for _, name := range []string{"vim/vimrc", "zshrc", "up", "self"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "dotfiles", name), nil, 0600))
+	}
+
+	filename := filepath.Join(dir, "dotfiles", "dotbro.toml")
+	content := `# "missing" = ".missing"
+[directories]
+dotfiles = "` + filepath.Join(dir, "dotfiles") + `"
+destination = "` + filepath.Join(dir, "home") + `"
+destinaton = "typo"
+
+[mapping]
+"vim/vimrc" = ".vimrc"
+missing = ".missing"
+"zshrc" = ".vimrc"
+"up" = "../up"
+"self" = "../dotfiles/self"
+
+[instal]
+conflict = "skip"
+`
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+
+	p, err := NewProfile(filename)
+	require.NoError(t, err)
+
+	diagnostics, err := CheckProfile(p)
+	require.NoError(t, err)
+
+	var lines []int
+	var messages []string
+	for _, d := range diagnostics {
+		assert.Equal(t, filename, d.File)
+		lines = append(lines, d.Line)
+		messages = append(messages, d.Message)
+	}
+	assert.Equal(t, []int{5, 9, 10, 11, 12, 14}, lines)
+	assert.Equal(t, []string{
+		"unknown key 'directories.destinaton'",
+		"mapping source 'missing' does not exist",
+		"destination '.vimrc' of 'zshrc' is already mapped from 'vim/vimrc' (line 8)",
+		"destination '../up' of 'up' is outside of the destination directory " + filepath.Join(dir, "home"),
+		"destination '../dotfiles/self' of 'self' is outside of the destination directory " + filepath.Join(dir, "home"),
+		"unknown key 'instal'",
+	}, messages)
+}
+
+func TestCheckProfile_JSON(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vimrc"), nil, 0600))
+
+	filename := filepath.Join(dir, "dotbro.json")
+	content := `{
+    "directories": {
+        "destination": "` + dir + `",
+        "backups": "/tmp"
+    },
+    "files": {
+        "excludes": ["vimrc", "README.md"]
+    }
+}
+`
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+
+	p, err := NewProfile(filename)
+	require.NoError(t, err)
+
+	diagnostics, err := CheckProfile(p)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{File: filename, Line: 4, Message: "unknown key 'directories.backups'"},
+		{File: filename, Line: 7, Message: "exclude 'README.md' matches no files"},
+	}, diagnostics)
+}
+
+func TestCheckProfile_Valid(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "dotfiles")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "git"), 0700))
+	for _, name := range []string{"git/config.work", "git/config.home"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	filename := filepath.Join(dir, "dotbro.toml")
+	content := `[directories]
+dotfiles = "` + dir + `"
+destination = "` + filepath.Join(dir, "..", "home") + `"
+
+[mapping]
+"git/config.work" = { destination = ".gitconfig", hostname = "work-*" }
+"git/config.home" = { destination = ".gitconfig", hostname = "home-*" }
+`
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+
+	p, err := NewProfile(filename)
+	require.NoError(t, err)
+
+	diagnostics, err := CheckProfile(p)
+	require.NoError(t, err)
+	assert.Empty(t, diagnostics)
+}
+
+func TestCheckProfile_Include(t *testing.T) {
+	t.Parallel()
+
+	home := t.TempDir()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vimrc"), nil, 0600))
+
+	base := filepath.Join(dir, "base.yaml")
+	require.NoError(t, os.WriteFile(base, []byte(`directories:
+  destination: `+home+`
+  backups: /tmp
+mapping:
+  vimrc: .vimrc
+  zshrc: .zshrc
+`), 0600))
+
+	filename := filepath.Join(dir, "dotbro.toml")
+	require.NoError(t, os.WriteFile(filename, []byte(`include = ["base.yaml"]
+
+[mapping]
+"vimrc" = ".config/vim/vimrc"
+"gvimrc" = ".config/vim/vimrc"
+`), 0600))
+
+	p, err := NewProfile(filename)
+	require.NoError(t, err)
+
+	diagnostics, err := CheckProfile(p)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{File: base, Line: 3, Message: "unknown key 'directories.backups'"},
+		{File: base, Line: 6, Message: "mapping source 'zshrc' does not exist"},
+		{File: filename, Line: 4, Message: "destination '.config/vim/vimrc' of 'vimrc' is already mapped from 'gvimrc' (line 5)"},
+		{File: filename, Line: 5, Message: "mapping source 'gvimrc' does not exist"},
+	}, diagnostics)
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "dotbro.toml:3: unknown key 'x'", Diagnostic{File: "dotbro.toml", Line: 3, Message: "unknown key 'x'"}.String())
+	assert.Equal(t, "dotbro.toml: bad", Diagnostic{File: "dotbro.toml", Message: "bad"}.String())
+}
+
+func TestTextLine(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`# [mapping] "vimrc" = ".vimrc"
+[directories]
+mapping = "not a section"
+
+[mapping]
+vimrc-old = ".vimrc.old"
+vimrc = ".vimrc"
+'zsh/zshrc' = ".zshrc"
+
+[mapping."git/config"]
+destination = ".gitconfig"
+`)
+
+	assert.Equal(t, 2, textLine(content, []string{"directories"}, ""))
+	assert.Equal(t, 7, textLine(content, []string{"mapping", "vimrc"}, ""))
+	assert.Equal(t, 8, textLine(content, []string{"mapping", "zsh/zshrc"}, ""))
+	assert.Equal(t, 11, textLine(content, []string{"mapping", "git/config", "destination"}, ""))
+	assert.Equal(t, 0, textLine(content, []string{"files"}, ""))
+	assert.Equal(t, 0, textLine(content, []string{".vimrc"}, ""))
+	assert.Equal(t, 7, textLine(content, []string{"mapping"}, ".vimrc"))
+}
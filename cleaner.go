@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -19,10 +18,12 @@ func NewCleaner(os OS, logger *slog.Logger) Cleaner {
 	}
 }
 
-func (c *Cleaner) CleanDeadSymlinks(ctx context.Context, dirPath string) error {
+// FindDeadSymlinks returns paths of broken symlinks located directly in dirPath.
+// It never changes the filesystem.
+func (c *Cleaner) FindDeadSymlinks(dirPath string) (deadSymlinks []string, err error) {
 	dir, err := c.os.Open(dirPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer func() {
@@ -34,24 +35,24 @@ func (c *Cleaner) CleanDeadSymlinks(ctx context.Context, dirPath string) error {
 
 	dirInfo, err := dir.Stat()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if !dirInfo.IsDir() {
-		return fmt.Errorf("Specified dirPath %s is not a directory", dirPath)
+		return nil, fmt.Errorf("Specified dirPath %s is not a directory", dirPath)
 	}
 
 	files, err := dir.Readdir(0)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return c.cleanFiles(ctx, dirPath, files)
+	return c.deadSymlinks(dirPath, files)
 }
 
-// Checks each file, if it is a bad symlink - removes it.
-func (c *Cleaner) cleanFiles(ctx context.Context, dirPath string, files []os.FileInfo) error {
-	removedAny := false
+// Checks each file, if it is a bad symlink - collects it.
+func (c *Cleaner) deadSymlinks(dirPath string, files []os.FileInfo) ([]string, error) {
+	var deadSymlinks []string
 	for _, fileInfo := range files {
 		if fileInfo.Mode()&os.ModeSymlink != os.ModeSymlink {
 			continue
@@ -65,24 +66,12 @@ func (c *Cleaner) cleanFiles(ctx context.Context, dirPath string, files []os.Fil
 		}
 
 		if !os.IsNotExist(err) {
-			return err
+			return nil, err
 		}
 
-		// file not exists => bad symlink, remove it
-
-		if err := c.os.Remove(filepath); err != nil {
-			return err
-		}
-
-		if !removedAny {
-			removedAny = true
-			c.logger.InfoContext(ctx, "Cleaning dead symlinks...")
-		}
-
-		c.logger.InfoContext(ctx, "removed broken symlink",
-			slog.String("status", "✓"),
-			slog.String("path", filepath))
+		// file not exists => bad symlink
+		deadSymlinks = append(deadSymlinks, filepath)
 	}
 
-	return nil
+	return deadSymlinks, nil
 }
@@ -8,11 +8,33 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-// TODO: the test is broken (cause of home).
-func TestCleaner_CleanDeadSymlinks(t *testing.T) {
+func TestCleaner_FindDeadSymlinks(t *testing.T) {
+	fakeOS := &FakeOS{
+		OpenResult: &FakeFile{
+			StatResult: &FakeFileInfo{
+				IsDirValue: true,
+			},
+			ReaddirResult: []os.FileInfo{
+				&FakeFileInfo{
+					NameValue: "dead",
+					ModeValue: os.ModeSymlink,
+				},
+			},
+		},
+		StatError:   os.ErrNotExist,
+		RemoveError: errors.New("Must not be called"),
+	}
+	cleaner := NewCleaner(fakeOS, newDiscardLogger())
+
+	deadSymlinks, err := cleaner.FindDeadSymlinks("/some/path")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/some/path/dead"}, deadSymlinks)
+}
+
+func TestCleaner_FindDeadSymlinks_Errors(t *testing.T) {
 	cases := []struct {
 		os            *FakeOS
-		dirpath       string
 		expectedError error
 	}{
 		{
@@ -20,7 +42,6 @@ func TestCleaner_CleanDeadSymlinks(t *testing.T) {
 			os: &FakeOS{
 				OpenError: errors.New("Cannot open dir"),
 			},
-			dirpath:       "/some/path",
 			expectedError: errors.New("Cannot open dir"),
 		},
 		{
@@ -30,7 +51,6 @@ func TestCleaner_CleanDeadSymlinks(t *testing.T) {
 					StatError: errors.New("Some error"),
 				},
 			},
-			dirpath:       "/some/path",
 			expectedError: errors.New("Some error"),
 		},
 		{
@@ -43,7 +63,6 @@ func TestCleaner_CleanDeadSymlinks(t *testing.T) {
 					ReaddirError: errors.New("Cannot read dir"),
 				},
 			},
-			dirpath:       "/some/path",
 			expectedError: errors.New("Specified dirPath /some/path is not a directory"),
 		},
 		{
@@ -56,43 +75,8 @@ func TestCleaner_CleanDeadSymlinks(t *testing.T) {
 					ReaddirError: errors.New("Cannot read dir"),
 				},
 			},
-			dirpath:       "/some/path",
 			expectedError: errors.New("Cannot read dir"),
 		},
-		{
-			// file is a dir
-			os: &FakeOS{
-				OpenResult: &FakeFile{
-					StatResult: &FakeFileInfo{
-						IsDirValue: true,
-					},
-					ReaddirResult: []os.FileInfo{
-						&FakeFileInfo{
-							ModeValue: os.ModeDir,
-						},
-					},
-				},
-			},
-			dirpath:       "/some/path",
-			expectedError: nil,
-		},
-		{
-			// file is a correct symlink
-			os: &FakeOS{
-				OpenResult: &FakeFile{
-					StatResult: &FakeFileInfo{
-						IsDirValue: true,
-					},
-					ReaddirResult: []os.FileInfo{
-						&FakeFileInfo{
-							ModeValue: os.ModeSymlink,
-						},
-					},
-				},
-			},
-			dirpath:       "/some/path",
-			expectedError: nil,
-		},
 		{
 			// file is a symlink, but error on stat
 			os: &FakeOS{
@@ -108,62 +92,16 @@ func TestCleaner_CleanDeadSymlinks(t *testing.T) {
 				},
 				StatError: os.ErrInvalid,
 			},
-			dirpath:       "/some/path",
 			expectedError: os.ErrInvalid,
 		},
-		{
-			// file is a symlink, but error on removal
-			os: &FakeOS{
-				OpenResult: &FakeFile{
-					StatResult: &FakeFileInfo{
-						IsDirValue: true,
-					},
-					ReaddirResult: []os.FileInfo{
-						&FakeFileInfo{
-							ModeValue: os.ModeSymlink,
-						},
-					},
-				},
-				StatError:   os.ErrNotExist,
-				RemoveError: os.ErrPermission,
-			},
-			dirpath:       "/some/path",
-			expectedError: os.ErrPermission,
-		},
-		{
-			// file is a symlink, successfully removed
-			os: &FakeOS{
-				OpenResult: &FakeFile{
-					StatResult: &FakeFileInfo{
-						IsDirValue: true,
-					},
-					ReaddirResult: []os.FileInfo{
-						&FakeFileInfo{
-							ModeValue: os.ModeSymlink,
-						},
-					},
-				},
-				StatError: os.ErrNotExist,
-			},
-			dirpath:       "/some/path",
-			expectedError: nil,
-		},
 	}
 
 	for _, c := range cases {
 		cleaner := NewCleaner(c.os, newDiscardLogger())
 
-		/*
-			// the hack
-			home, err := os.Open(os.ExpandEnv("$HOME"))
-			if err != nil {
-				t.Fatal("Cannot open $HOME")
-			}
-			c.os.OpenResult = home
-		*/
-
-		err := cleaner.CleanDeadSymlinks(t.Context(), c.dirpath)
+		deadSymlinks, err := cleaner.FindDeadSymlinks("/some/path")
 
 		assert.Equal(t, c.expectedError, err)
+		assert.Nil(t, deadSymlinks)
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // defaultConfigFilepath is path to dotbro config file.
@@ -71,6 +72,18 @@ func (c *Config) GetProfilePaths() []string {
 	return paths
 }
 
+// MatchProfilePath reports whether the profile at path is chosen by selector.
+// The selector is either the path of the profile file, its name or its name
+// without the extension, e.g. "work" chooses "/home/user/dotfiles/work.toml".
+func MatchProfilePath(path, selector string) bool {
+	if abs, err := filepath.Abs(selector); err == nil && abs == path {
+		return true
+	}
+
+	name := filepath.Base(path)
+	return selector == name || selector == strings.TrimSuffix(name, filepath.Ext(name))
+}
+
 // Load reads Config data from config file.
 // It maintains backward compatibility by migrating old profile.json format.
 func (c *Config) Load(ctx context.Context) error {
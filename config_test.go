@@ -47,6 +47,17 @@ func TestConfig_GetProfilePaths(t *testing.T) {
 	assert.Equal(t, "/path/two", paths[1])
 }
 
+func TestMatchProfilePath(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, MatchProfilePath("/dotfiles/work.toml", "/dotfiles/work.toml"))
+	assert.True(t, MatchProfilePath("/dotfiles/work.toml", "/dotfiles/../dotfiles/work.toml"))
+	assert.True(t, MatchProfilePath("/dotfiles/work.toml", "work.toml"))
+	assert.True(t, MatchProfilePath("/dotfiles/work.toml", "work"))
+	assert.False(t, MatchProfilePath("/dotfiles/work.toml", "home"))
+	assert.False(t, MatchProfilePath("/dotfiles/work.toml", "/other/work.json"))
+}
+
 func TestConfig_Load_NotExists(t *testing.T) {
 	t.Parallel()
 
@@ -9,19 +9,51 @@ func ParseArguments(argv []string) (map[string]interface{}, error) {
 	usage := `dotbro - simple yet effective dotfiles manager.
 
 Usage:
-  dotbro [options] [--config=<filepath>]
+  dotbro [options]
   dotbro add [options] <filename>
+  dotbro adopt [options] [<filename>]
+  dotbro check [options]
+  dotbro clean [options]
+  dotbro forget [options] [--delete-source] <filename>
+  dotbro restore [options] (--list | --run=<id> | <filename>)
+  dotbro status [options]
+  dotbro uninstall [options]
   dotbro -h | --help
   dotbro --version
 
 Common options:
-  -c --config=<filepath>  Dotbro profile file in JSON or TOML format.
+  -c --config=<filepath>  Dotbro profile file in TOML, JSON or YAML format.
+  -n --dry-run            Show what would be done without changing anything.
+  -p --profile=<profile>  Process only the configured profile chosen by its
+                          path or name, e.g. "work" for "work.toml".
   -q --quiet              Quiet mode. Do not print any output, except warnings
                           and errors.
   -v --verbose            Verbose mode. Detailed output.
 
+Install options:
+  --conflict=<policy>     What to do with an existing file at a destination:
+                          backup, skip, overwrite, fail, adopt or prompt.
+                          Overrides the profile settings.
+
 Add options:
-  <filename>              File to add.
+  <filename>              File or directory to add.
+  --as=<path>             Path to move the file to, relative to the dotfiles
+                          directory. By default, the path of the file relative
+                          to the destination directory is used.
+
+Adopt options:
+  <filename>              Destination file to adopt. By default, all mapped
+                          destinations are adopted.
+
+Forget options:
+  <filename>              Destination file to stop managing. The symlink is
+                          replaced with a copy of the source.
+  --delete-source         Delete the source from the dotfiles directory.
+
+Restore options:
+  -l --list               List backed up files.
+  --run=<id>              Restore all files backed up during the run <id>.
+  <filename>              File to restore.
 
 Other options:
   -h --help               Show this helpful info.
@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -10,3 +11,87 @@ func TestParseArguments(t *testing.T) {
 	_, err := ParseArguments([]string{"--quiet"})
 	require.NoError(t, err)
 }
+
+func TestParseArguments_ConfigForCommands(t *testing.T) {
+	for _, argv := range [][]string{
+		{"-c", "dotbro.toml"},
+		{"status", "-c", "dotbro.toml"},
+		{"uninstall", "--config=dotbro.toml"},
+		{"clean", "-c", "dotbro.toml"},
+	} {
+		args, err := ParseArguments(argv)
+		require.NoError(t, err)
+		assert.Equal(t, "dotbro.toml", args["--config"], argv)
+	}
+}
+
+func TestParseArguments_Restore(t *testing.T) {
+	args, err := ParseArguments([]string{"restore", "--list"})
+	require.NoError(t, err)
+	assert.Equal(t, true, args["restore"])
+	assert.Equal(t, true, args["--list"])
+
+	args, err = ParseArguments([]string{"restore", "--run=20160923T120000Z"})
+	require.NoError(t, err)
+	assert.Equal(t, "20160923T120000Z", args["--run"])
+
+	args, err = ParseArguments([]string{"restore", "--dry-run", "/home/user/.vimrc"})
+	require.NoError(t, err)
+	assert.Equal(t, "/home/user/.vimrc", args["<filename>"])
+	assert.Equal(t, true, args["--dry-run"])
+}
+
+func TestParseArguments_Conflict(t *testing.T) {
+	args, err := ParseArguments([]string{"--conflict=skip", "-c", "dotbro.toml"})
+	require.NoError(t, err)
+	assert.Equal(t, "skip", args["--conflict"])
+
+	args, err = ParseArguments([]string{"-q"})
+	require.NoError(t, err)
+	assert.Nil(t, args["--conflict"])
+}
+
+func TestParseArguments_Adopt(t *testing.T) {
+	args, err := ParseArguments([]string{"adopt"})
+	require.NoError(t, err)
+	assert.Equal(t, true, args["adopt"])
+	assert.Nil(t, args["<filename>"])
+
+	args, err = ParseArguments([]string{"adopt", "-n", "/home/user/.vimrc"})
+	require.NoError(t, err)
+	assert.Equal(t, "/home/user/.vimrc", args["<filename>"])
+}
+
+func TestParseArguments_AddAs(t *testing.T) {
+	args, err := ParseArguments([]string{"add", "/home/user/.config/nvim"})
+	require.NoError(t, err)
+	assert.Nil(t, args["--as"])
+
+	args, err = ParseArguments([]string{"add", "--as=nvim", "/home/user/.config/nvim"})
+	require.NoError(t, err)
+	assert.Equal(t, "nvim", args["--as"])
+	assert.Equal(t, "/home/user/.config/nvim", args["<filename>"])
+}
+
+func TestParseArguments_Profile(t *testing.T) {
+	args, err := ParseArguments([]string{"add", "--profile=work", "/home/user/.vimrc"})
+	require.NoError(t, err)
+	assert.Equal(t, "work", args["--profile"])
+
+	args, err = ParseArguments([]string{"clean", "-p", "home"})
+	require.NoError(t, err)
+	assert.Equal(t, true, args["clean"])
+	assert.Equal(t, "home", args["--profile"])
+}
+
+func TestParseArguments_Forget(t *testing.T) {
+	args, err := ParseArguments([]string{"forget", "/home/user/.vimrc"})
+	require.NoError(t, err)
+	assert.Equal(t, true, args["forget"])
+	assert.Equal(t, false, args["--delete-source"])
+	assert.Equal(t, "/home/user/.vimrc", args["<filename>"])
+
+	args, err = ParseArguments([]string{"forget", "--delete-source", "/home/user/.vimrc"})
+	require.NoError(t, err)
+	assert.Equal(t, true, args["--delete-source"])
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
 // Copy copies a file from src to dst.
@@ -31,8 +37,62 @@ func Copy(osfs OS, src, dst string) error {
 		}
 	}
 
-	err = copyFileContents(osfs, src, dst)
-	return err
+	if err = copyFileContents(osfs, src, dst); err != nil {
+		return err
+	}
+
+	return osfs.Chmod(dst, sfi.Mode().Perm())
+}
+
+// Checksum returns hex-encoded SHA-256 checksum of the file contents.
+func Checksum(osfs OS, filename string) (sum string, err error) {
+	f, err := osfs.Open(filename)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteFile writes data to the file dst, creating missing parent directories,
+// and sets its permissions to perm.
+func WriteFile(osfs OS, dst string, data []byte, perm os.FileMode) (err error) {
+	if err = osfs.MkdirAll(path.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	out, err := osfs.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		outCloseErr := out.Close()
+		if err == nil {
+			err = outCloseErr
+		}
+	}()
+
+	if _, err = out.Write(data); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+
+	return osfs.Chmod(dst, perm)
 }
 
 // copyFileContents copies the contents of the file named src to the file named
@@ -75,3 +135,74 @@ func copyFileContents(osfs OS, src, dst string) (err error) {
 	err = out.Sync()
 	return err
 }
+
+// WriteFileAtomic writes data to the file named by filename.
+// Data is written to a temporary file first, which then replaces the target
+// file, so readers never see a partially written file.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// IsInside reports whether path p is dir itself or is located inside dir.
+// Both paths must be absolute.
+func IsInside(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// CopyPair is a pair of files where Src is copied to Dst.
+type CopyPair struct {
+	Src string
+	Dst string
+}
+
+// CopyPairs returns pairs of regular files to copy from src to dst.
+// If src is a directory, it is walked recursively and every regular file in
+// it is paired with the same relative path under dst.
+func CopyPairs(src, dst string) ([]CopyPair, error) {
+	var pairs []CopyPair
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, CopyPair{Src: p, Dst: filepath.Join(dst, rel)})
+		return nil
+	})
+	return pairs, err
+}
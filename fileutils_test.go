@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -164,3 +165,46 @@ func TestCopy(t *testing.T) {
 		assert.Equal(t, testcase.expectedError, err)
 	}
 }
+
+func TestIsInside(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsInside("/home/user/dotfiles", "/home/user/dotfiles"))
+	assert.True(t, IsInside("/home/user/dotfiles", "/home/user/dotfiles/vim/vimrc"))
+	assert.False(t, IsInside("/home/user/dotfiles", "/home/user/dotfiles-old/vimrc"))
+	assert.False(t, IsInside("/home/user/dotfiles", "/home/user"))
+	assert.False(t, IsInside("/home/user/dotfiles", "/etc/passwd"))
+}
+
+func TestChecksum(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, []byte("dotbro"), 0600))
+
+	sum, err := Checksum(osfs, file)
+
+	require.NoError(t, err)
+	assert.Equal(t, "d5c271ff392da5d2b13b77cad57937baecafe94435c69515e9d6fc64d8115f7f", sum)
+}
+
+func TestCopyPairs(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a"), nil, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "sub", "b"), nil, 0600))
+	require.NoError(t, os.Symlink(filepath.Join(src, "a"), filepath.Join(src, "link")))
+
+	pairs, err := CopyPairs(src, "/dest")
+	require.NoError(t, err)
+	assert.Equal(t, []CopyPair{
+		{Src: filepath.Join(src, "a"), Dst: "/dest/a"},
+		{Src: filepath.Join(src, "sub", "b"), Dst: "/dest/sub/b"},
+	}, pairs)
+
+	pairs, err = CopyPairs(filepath.Join(src, "a"), "/dest/file")
+	require.NoError(t, err)
+	assert.Equal(t, []CopyPair{{Src: filepath.Join(src, "a"), Dst: "/dest/file"}}, pairs)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ProfileFormat is a file format of profiles.
+type ProfileFormat struct {
+	// Decode decodes the profile file content into data.
+	Decode func(content []byte, data *ProfileData) error
+
+	// Object decodes the content into a generic object.
+	Object func(content []byte) (map[string]any, error)
+
+	// UnknownKeys returns paths of keys in the content that profiles do not support.
+	UnknownKeys func(content []byte) ([][]string, error)
+
+	// Line returns the line number of the key path in the content or, if value
+	// is not empty, of the string value of the key path, e.g. an item of an array.
+	// It returns 0 if nothing is found.
+	Line func(content []byte, key []string, value string) int
+
+	// ErrorLine returns the line number of the Decode error in the content,
+	// or 0 if the error has no position.
+	ErrorLine func(content []byte, err error) int
+
+	// AddMapping adds the "src" = "dest" entry to the mapping in the content.
+	AddMapping func(content []byte, src, dest string) ([]byte, error)
+
+	// RemoveMapping removes the entry with the src key from the mapping in the content.
+	RemoveMapping func(content []byte, src string) ([]byte, error)
+}
+
+var (
+	tomlFormat = ProfileFormat{
+		Decode:        decodeTOMLProfile,
+		Object:        tomlObject,
+		UnknownKeys:   tomlUnknownKeys,
+		Line:          textLine,
+		ErrorLine:     tomlErrorLine,
+		AddMapping:    addTOMLMapping,
+		RemoveMapping: removeTOMLMapping,
+	}
+
+	jsonFormat = ProfileFormat{
+		Decode:        decodeJSONProfile,
+		Object:        jsonObject,
+		UnknownKeys:   jsonUnknownKeys,
+		Line:          textLine,
+		ErrorLine:     jsonErrorLine,
+		AddMapping:    addJSONMapping,
+		RemoveMapping: removeJSONMapping,
+	}
+
+	yamlFormat = ProfileFormat{
+		Decode:        decodeYAMLProfile,
+		Object:        yamlObject,
+		UnknownKeys:   yamlUnknownKeys,
+		Line:          yamlLine,
+		ErrorLine:     yamlErrorLine,
+		AddMapping:    addYAMLMapping,
+		RemoveMapping: removeYAMLMapping,
+	}
+)
+
+// profileExtensions are the supported profile file extensions, in order of preference.
+var profileExtensions = []string{".toml", ".json", ".yaml", ".yml"}
+
+// profileFormats maps profile file extensions to their formats.
+var profileFormats = map[string]ProfileFormat{
+	".toml": tomlFormat,
+	".json": jsonFormat,
+	".yaml": yamlFormat,
+	".yml":  yamlFormat,
+}
+
+// ProfileFormatFor returns the format of the profile file by its extension.
+func ProfileFormatFor(filename string) (ProfileFormat, error) {
+	format, ok := profileFormats[filepath.Ext(filename)]
+	if !ok {
+		last := len(profileExtensions) - 1
+		return ProfileFormat{}, fmt.Errorf(
+			"unknown profile file extension %s: supported extensions are %s and %s",
+			filename, strings.Join(profileExtensions[:last], ", "), profileExtensions[last],
+		)
+	}
+	return format, nil
+}
@@ -11,6 +11,7 @@ type OS interface {
 	Create(name string) (*os.File, error)
 
 	MkdirAll(path string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
 
 	Symlink(oldname, newname string) error
 	Readlink(name string) (string, error)
@@ -21,6 +22,7 @@ type OS interface {
 
 	Rename(oldpath, newpath string) error
 	Remove(name string) error
+	RemoveAll(path string) error
 }
 
 type File interface {
@@ -46,6 +48,10 @@ func (f *OSFS) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
+func (f *OSFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
 func (f *OSFS) Symlink(oldname, newname string) error {
 	return os.Symlink(oldname, newname)
 }
@@ -73,3 +79,7 @@ func (f *OSFS) Rename(oldpath, newpath string) error {
 func (f *OSFS) Remove(name string) error {
 	return os.Remove(name)
 }
+
+func (f *OSFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
@@ -12,6 +12,7 @@ type FakeOS struct {
 	CreateResult     *os.File
 	CreateError      error
 	MkdirAllError    error
+	ChmodError       error
 	SymlinkError     error
 	ReadlinkResult   string
 	ReadlinkError    error
@@ -22,6 +23,7 @@ type FakeOS struct {
 	IsNotExistResult bool
 	RenameError      error
 	RemoveError      error
+	RemoveAllError   error
 }
 
 func (f *FakeOS) Open(name string) (File, error) {
@@ -36,6 +38,10 @@ func (f *FakeOS) MkdirAll(path string, perm os.FileMode) error {
 	return f.MkdirAllError
 }
 
+func (f *FakeOS) Chmod(name string, mode os.FileMode) error {
+	return f.ChmodError
+}
+
 func (f *FakeOS) Symlink(oldname, newname string) error {
 	return f.SymlinkError
 }
@@ -64,6 +70,10 @@ func (f *FakeOS) Remove(name string) error {
 	return f.RemoveError
 }
 
+func (f *FakeOS) RemoveAll(path string) error {
+	return f.RemoveAllError
+}
+
 // FakeFile is kinda a os.File mock.
 type FakeFile struct {
 	CloseError    error
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IsGlob reports whether the mapping source is a glob pattern.
+func IsGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// GlobBase returns the leading part of the pattern without any glob
+// metacharacters, e.g. "zsh" for "zsh/*.zsh".
+func GlobBase(pattern string) string {
+	var base []string
+	for _, part := range strings.Split(pattern, "/") {
+		if IsGlob(part) {
+			break
+		}
+		base = append(base, part)
+	}
+	return path.Join(base...)
+}
+
+// MatchGlob reports whether the slash-separated name matches the pattern.
+// The pattern has the syntax of path.Match, and additionally "**" as a whole
+// path element matches zero or more path elements.
+func MatchGlob(pattern, name string) (bool, error) {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern, name []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				matched, err := matchGlobParts(pattern[1:], name[i:])
+				if matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(name) == 0 {
+			return false, nil
+		}
+		matched, err := path.Match(pattern[0], name[0])
+		if !matched || err != nil {
+			return false, err
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0, nil
+}
+
+// ExpandGlob returns files in dir matching the pattern, relative to dir and
+// sorted. Directories are never matched themselves, and ".git" directories
+// are not searched.
+func ExpandGlob(dir, pattern string) ([]string, error) {
+	for _, part := range strings.Split(pattern, "/") {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	root := filepath.Join(dir, GlobBase(pattern))
+	if _, err := osfs.Stat(root); osfs.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var matches []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		matched, err := MatchGlob(pattern, rel)
+		if err != nil {
+			return err
+		}
+		if matched {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+
+	sort.Strings(matches)
+	return matches, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsGlob(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsGlob("zsh/*"))
+	assert.True(t, IsGlob("bin/**"))
+	assert.True(t, IsGlob("vim/vimrc.?"))
+	assert.True(t, IsGlob("x/[ab]"))
+	assert.False(t, IsGlob("vim/vimrc"))
+}
+
+func TestGlobBase(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "zsh", GlobBase("zsh/*"))
+	assert.Equal(t, "config/nvim", GlobBase("config/nvim/**/*.lua"))
+	assert.Equal(t, "", GlobBase("*"))
+}
+
+func TestMatchGlob(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{pattern: "zsh/*", name: "zsh/zshrc", expected: true},
+		{pattern: "zsh/*", name: "zsh/plugins/git.zsh", expected: false},
+		{pattern: "bin/**", name: "bin/tool", expected: true},
+		{pattern: "bin/**", name: "bin/sub/dir/tool", expected: true},
+		{pattern: "nvim/**/*.lua", name: "nvim/init.lua", expected: true},
+		{pattern: "nvim/**/*.lua", name: "nvim/lua/plugins/lsp.lua", expected: true},
+		{pattern: "nvim/**/*.lua", name: "nvim/lua/README.md", expected: false},
+		{pattern: "*.sh", name: "bin/run.sh", expected: false},
+	}
+
+	for _, c := range cases {
+		matched, err := MatchGlob(c.pattern, c.name)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, matched, "%s ~ %s", c.pattern, c.name)
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"zsh/zshrc", "zsh/zprofile", "zsh/plugins/git.zsh", "bin/tool", ".git/config"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0600))
+	}
+
+	files, err := ExpandGlob(dir, "zsh/*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zsh/zprofile", "zsh/zshrc"}, files)
+
+	files, err = ExpandGlob(dir, "**")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bin/tool", "zsh/plugins/git.zsh", "zsh/zprofile", "zsh/zshrc"}, files)
+
+	files, err = ExpandGlob(dir, "missing/*")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, err = ExpandGlob(dir, "zsh/[")
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
 )
 
 type Linker struct {
@@ -20,10 +21,54 @@ func NewLinker(os OS, logger *slog.Logger) Linker {
 	}
 }
 
+// DestState describes the state of a destination path relative to its source.
+type DestState int
+
+const (
+	// DestMissing means nothing exists at the destination path.
+	DestMissing DestState = iota
+	// DestLinked means the destination is a symlink to the source.
+	DestLinked
+	// DestWrongLink means the destination is a symlink pointing elsewhere.
+	DestWrongLink
+	// DestBlocked means the destination is a real file or directory.
+	DestBlocked
+	// DestUpToDate means the destination is a copy with the expected content.
+	DestUpToDate
+	// DestOutdated means the destination is an unmodified copy made by dotbro
+	// from an older version of the source.
+	DestOutdated
+	// DestModified means the destination is a copy made by dotbro that was
+	// modified afterwards.
+	DestModified
+)
+
+// String returns a human-readable description of the state.
+func (s DestState) String() string {
+	switch s {
+	case DestMissing:
+		return "missing"
+	case DestLinked:
+		return "linked"
+	case DestWrongLink:
+		return "wrong target"
+	case DestBlocked:
+		return "blocked"
+	case DestUpToDate:
+		return "up to date"
+	case DestOutdated:
+		return "outdated"
+	case DestModified:
+		return "modified"
+	default:
+		return fmt.Sprintf("unknown state %d", int(s))
+	}
+}
+
 // Move moves oldpath to newpath, creating target directories if need.
 func (l *Linker) Move(ctx context.Context, oldpath, newpath string) error {
-	// check if oldpath file exists
-	_, err := l.os.Stat(oldpath)
+	// check if oldpath file exists; a dangling symlink is moved as is
+	_, err := l.os.Lstat(oldpath)
 	if l.os.IsNotExist(err) {
 		return fmt.Errorf("File %s not exists", oldpath)
 	}
@@ -44,6 +89,46 @@ func (l *Linker) Move(ctx context.Context, oldpath, newpath string) error {
 	return err
 }
 
+// Copy copies srcAbs to destAbs, replacing the file at destAbs.
+func (l *Linker) Copy(srcAbs, destAbs string) error {
+	return Copy(l.os, srcAbs, destAbs)
+}
+
+// CopyAll copies the file or every file of the directory srcAbs to destAbs.
+func (l *Linker) CopyAll(srcAbs, destAbs string) error {
+	files, err := CopyPairs(srcAbs, destAbs)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = Copy(l.os, file.Src, file.Dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render writes the rendered content of template srcAbs to destAbs,
+// replacing the file at destAbs. The file gets permissions of the template.
+func (l *Linker) Render(srcAbs, destAbs string, content []byte) error {
+	sfi, err := l.os.Stat(srcAbs)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(l.os, destAbs, content, sfi.Mode().Perm())
+}
+
+// Remove removes the file or empty directory at path.
+func (l *Linker) Remove(path string) error {
+	return l.os.Remove(path)
+}
+
+// RemoveAll removes path and everything it contains.
+func (l *Linker) RemoveAll(path string) error {
+	return l.os.RemoveAll(path)
+}
+
 // SetSymlink symlinks scrAbs to destAbs.
 func (l *Linker) SetSymlink(srcAbs string, destAbs string) error {
 	dir := path.Dir(destAbs)
@@ -54,47 +139,108 @@ func (l *Linker) SetSymlink(srcAbs string, destAbs string) error {
 	return l.os.Symlink(srcAbs, destAbs)
 }
 
-// NeedSymlink reports whether source file needs to be symlinked to destination path.
-func (l *Linker) NeedSymlink(ctx context.Context, src, dest string) (bool, error) {
+// SetRelativeSymlink symlinks srcAbs to destAbs using a target relative to
+// the destAbs directory, e.g. "../dotfiles/vim/vimrc".
+func (l *Linker) SetRelativeSymlink(srcAbs string, destAbs string) error {
+	dir := path.Dir(destAbs)
+	target, err := filepath.Rel(dir, srcAbs)
+	if err != nil {
+		return err
+	}
+
+	if err = l.os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	return l.os.Symlink(target, destAbs)
+}
+
+// State reports the state of destination path relative to source file.
+// It never changes the filesystem.
+func (l *Linker) State(ctx context.Context, src, dest string) (DestState, error) {
 	fi, err := l.os.Lstat(dest)
 	if l.os.IsNotExist(err) {
-		return true, nil
+		return DestMissing, nil
 	}
 	if err != nil {
-		return false, err
+		return DestMissing, err
 	}
 
 	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
-		return true, nil
+		return DestBlocked, nil
 	}
 
 	target, err := l.os.Readlink(dest)
 	if err != nil {
-		return false, err
+		return DestMissing, err
+	}
+
+	// Relative and absolute targets pointing to the same file are equivalent.
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(dest), target)
 	}
 
-	if target == src {
+	if path.Clean(target) == path.Clean(src) {
 		l.logger.DebugContext(ctx, "correct symlink",
 			slog.String("status", "✓"),
 			slog.String("path", dest))
-		return false, nil
+		return DestLinked, nil
 	}
 
-	// here dest is a wrong symlink
+	return DestWrongLink, nil
+}
 
-	if err = l.os.Remove(dest); err != nil {
-		return false, err
+// ContentState reports the state of destination path that is expected to be a
+// copy with the checksum wantSum. recordedSum is the checksum of the copy
+// dotbro made last time, if any. It never changes the filesystem.
+func (l *Linker) ContentState(ctx context.Context, wantSum, dest, recordedSum string) (DestState, error) {
+	fi, err := l.os.Lstat(dest)
+	if l.os.IsNotExist(err) {
+		return DestMissing, nil
+	}
+	if err != nil {
+		return DestMissing, err
 	}
-	l.logger.InfoContext(ctx, "delete wrong symlink",
-		slog.String("status", "✓"),
-		slog.String("path", dest))
 
-	return true, nil
+	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		return DestWrongLink, nil
+	}
+	if !fi.Mode().IsRegular() {
+		return DestBlocked, nil
+	}
+
+	sum, err := Checksum(l.os, dest)
+	if err != nil {
+		return DestMissing, err
+	}
+
+	switch {
+	case sum == wantSum:
+		l.logger.DebugContext(ctx, "copy is up to date",
+			slog.String("status", "✓"),
+			slog.String("path", dest))
+		return DestUpToDate, nil
+	case recordedSum == "":
+		return DestBlocked, nil
+	case sum == recordedSum:
+		return DestOutdated, nil
+	default:
+		return DestModified, nil
+	}
 }
 
-// NeedBackup reports whether destination path needs to be backed up.
-func (l *Linker) NeedBackup(dest string) (bool, error) {
-	fi, err := l.os.Lstat(dest)
+// Unfolded reports whether src is a directory and dest is a real directory,
+// so the source directory has to be linked file by file instead of as a whole.
+func (l *Linker) Unfolded(src, dest string) (bool, error) {
+	sfi, err := l.os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	if !sfi.IsDir() {
+		return false, nil
+	}
+
+	dfi, err := l.os.Lstat(dest)
 	if l.os.IsNotExist(err) {
 		return false, nil
 	}
@@ -102,9 +248,5 @@ func (l *Linker) NeedBackup(dest string) (bool, error) {
 		return false, err
 	}
 
-	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
-		return true, nil
-	}
-
-	return false, nil
+	return dfi.IsDir(), nil
 }
@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLinker_Move(t *testing.T) {
@@ -18,7 +20,7 @@ func TestLinker_Move(t *testing.T) {
 		{
 			// Failure when IsExists fails
 			os: &FakeOS{
-				StatError: errors.New("Some error"),
+				LstatError: errors.New("Some error"),
 			},
 			expectedError: errors.New("Some error"),
 		},
@@ -107,12 +109,30 @@ func TestLinker_SetSymlink(t *testing.T) {
 	}
 }
 
-func TestLinker_NeedSymlink(t *testing.T) {
+func TestLinker_SetRelativeSymlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "dotfiles", "vim", "vimrc")
+	dest := filepath.Join(tmpDir, "home", ".vimrc")
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0700))
+	require.NoError(t, os.WriteFile(src, []byte("set nocompatible"), 0600))
+
+	linker := NewLinker(osfs, newDiscardLogger())
+
+	require.NoError(t, linker.SetRelativeSymlink(src, dest))
+
+	target, err := os.Readlink(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "../dotfiles/vim/vimrc", target)
+
+	state, err := linker.State(t.Context(), src, dest)
+	require.NoError(t, err)
+	assert.Equal(t, DestLinked, state)
+}
+
+func TestLinker_State(t *testing.T) {
 	cases := []struct {
 		os             *FakeOS
-		src            string
-		dest           string
-		expectedResult bool
+		expectedResult DestState
 		expectedError  error
 	}{
 		{
@@ -120,152 +140,152 @@ func TestLinker_NeedSymlink(t *testing.T) {
 				LstatError:       os.ErrNotExist,
 				IsNotExistResult: true,
 			},
-			src:            "/src/path",
-			dest:           "/some/non-existent/path",
-			expectedResult: true,
-			expectedError:  nil,
+			expectedResult: DestMissing,
 		},
 		{
 			os: &FakeOS{
 				LstatError: errors.New("Some error"),
 			},
-			src:            "/src/path",
-			dest:           "/dest/path",
-			expectedResult: false,
+			expectedResult: DestMissing,
 			expectedError:  errors.New("Some error"),
 		},
 		{
 			os: &FakeOS{
 				LstatFileInfo: &FakeFileInfo{
-					ModeValue: 0, // regular file
+					ModeValue: os.ModeSymlink,
 				},
+				ReadlinkError: errors.New("Failed to read a link"),
 			},
-			src:            "/src/path",
-			dest:           "/dest/path",
-			expectedResult: true,
-			expectedError:  nil,
+			expectedResult: DestMissing,
+			expectedError:  errors.New("Failed to read a link"),
 		},
 		{
 			os: &FakeOS{
 				LstatFileInfo: &FakeFileInfo{
-					ModeValue: os.ModeSymlink,
+					ModeValue: 0, // regular file
 				},
-				ReadlinkError: errors.New("Failed to read a link"),
 			},
-			src:            "/src/path",
-			dest:           "/dest/path",
-			expectedResult: false,
-			expectedError:  errors.New("Failed to read a link"),
+			expectedResult: DestBlocked,
 		},
 		{
-			// dest is already a correct symlink to src
 			os: &FakeOS{
 				LstatFileInfo: &FakeFileInfo{
 					ModeValue: os.ModeSymlink,
 				},
 				ReadlinkResult: "/src/path",
 			},
-			src:            "/src/path",
-			dest:           "/dest/path",
-			expectedResult: false,
-			expectedError:  nil,
+			expectedResult: DestLinked,
 		},
 		{
-			// dest is wrong symlink but failed to remove it
 			os: &FakeOS{
 				LstatFileInfo: &FakeFileInfo{
 					ModeValue: os.ModeSymlink,
 				},
 				ReadlinkResult: "/some/incorrect/path",
-				RemoveError:    errors.New("Cannot remove"),
 			},
-			src:            "/src/path",
-			dest:           "/dest/path",
-			expectedResult: false,
-			expectedError:  errors.New("Cannot remove"),
+			expectedResult: DestWrongLink,
 		},
 		{
-			// dest is wrong symlink and it removes successfully
+			// relative target pointing to the source
 			os: &FakeOS{
 				LstatFileInfo: &FakeFileInfo{
 					ModeValue: os.ModeSymlink,
 				},
-				ReadlinkResult: "/some/incorrect/path",
-				RemoveError:    nil,
+				ReadlinkResult: "../src/path",
 			},
-			src:            "/src/path",
-			dest:           "/dest/path",
-			expectedResult: true,
-			expectedError:  nil,
+			expectedResult: DestLinked,
+		},
+		{
+			// relative target pointing elsewhere
+			os: &FakeOS{
+				LstatFileInfo: &FakeFileInfo{
+					ModeValue: os.ModeSymlink,
+				},
+				ReadlinkResult: "path",
+			},
+			expectedResult: DestWrongLink,
 		},
 	}
 
 	for _, c := range cases {
 		linker := NewLinker(c.os, newDiscardLogger())
 
-		result, err := linker.NeedSymlink(t.Context(), c.src, c.dest)
+		result, err := linker.State(t.Context(), "/src/path", "/dest/path")
 
-		assert.Equal(t, c.expectedResult, result)
 		assert.Equal(t, c.expectedError, err)
+		assert.Equal(t, c.expectedResult, result)
 	}
 }
 
-func TestLinker_NeedBackup(t *testing.T) {
+func TestDestState_String(t *testing.T) {
+	assert.Equal(t, "missing", DestMissing.String())
+	assert.Equal(t, "linked", DestLinked.String())
+	assert.Equal(t, "wrong target", DestWrongLink.String())
+	assert.Equal(t, "blocked", DestBlocked.String())
+}
+
+func TestLinker_Unfolded(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcDir := filepath.Join(tmpDir, "dotfiles", "config")
+	srcFile := filepath.Join(srcDir, "file")
+	realDir := filepath.Join(tmpDir, "home", ".config")
+	linkDir := filepath.Join(tmpDir, "home", ".config-link")
+	require.NoError(t, os.MkdirAll(srcDir, 0700))
+	require.NoError(t, os.WriteFile(srcFile, nil, 0600))
+	require.NoError(t, os.MkdirAll(realDir, 0700))
+	require.NoError(t, os.Symlink(srcDir, linkDir))
+
+	linker := NewLinker(osfs, newDiscardLogger())
+
 	cases := []struct {
-		os             *FakeOS
+		src      string
+		dest     string
+		expected bool
+	}{
+		{src: srcDir, dest: realDir, expected: true},
+		{src: srcDir, dest: linkDir, expected: false},
+		{src: srcDir, dest: filepath.Join(tmpDir, "home", ".missing"), expected: false},
+		{src: srcFile, dest: realDir, expected: false},
+	}
+
+	for _, c := range cases {
+		unfolded, err := linker.Unfolded(c.src, c.dest)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, unfolded, "%s -> %s", c.src, c.dest)
+	}
+}
+
+func TestLinker_ContentState(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "file")
+	link := filepath.Join(tmpDir, "link")
+	require.NoError(t, os.WriteFile(file, []byte("content"), 0600))
+	require.NoError(t, os.Symlink(file, link))
+
+	sum, err := Checksum(osfs, file)
+	require.NoError(t, err)
+
+	cases := []struct {
+		wantSum        string
 		dest           string
-		expectedResult bool
-		expectedError  error
+		recordedSum    string
+		expectedResult DestState
 	}{
-		{
-			// dest path not exists
-			os: &FakeOS{
-				LstatError:       os.ErrNotExist,
-				IsNotExistResult: true,
-			},
-			dest:           "/some/non-existent/path",
-			expectedResult: false,
-			expectedError:  nil,
-		},
-		{
-			// Lstat returned error
-			os: &FakeOS{
-				LstatError: errors.New("Some error"),
-			},
-			dest:           "/dest/path",
-			expectedResult: false,
-			expectedError:  errors.New("Some error"),
-		},
-		{
-			// dest path is not a symlink
-			os: &FakeOS{
-				LstatFileInfo: &FakeFileInfo{
-					ModeValue: 0, // regular file
-				},
-			},
-			dest:           "/dest/path",
-			expectedResult: true,
-			expectedError:  nil,
-		},
-		{
-			// dest path is a symlink
-			os: &FakeOS{
-				LstatFileInfo: &FakeFileInfo{
-					ModeValue: os.ModeSymlink,
-				},
-			},
-			dest:           "/dest/path",
-			expectedResult: false,
-			expectedError:  nil,
-		},
+		{wantSum: sum, dest: filepath.Join(tmpDir, "missing"), expectedResult: DestMissing},
+		{wantSum: sum, dest: link, expectedResult: DestWrongLink},
+		{wantSum: sum, dest: tmpDir, expectedResult: DestBlocked},
+		{wantSum: sum, dest: file, expectedResult: DestUpToDate},
+		{wantSum: "new", dest: file, recordedSum: "", expectedResult: DestBlocked},
+		{wantSum: "new", dest: file, recordedSum: sum, expectedResult: DestOutdated},
+		{wantSum: "new", dest: file, recordedSum: "old", expectedResult: DestModified},
 	}
 
 	for _, c := range cases {
-		linker := NewLinker(c.os, newDiscardLogger())
+		linker := NewLinker(osfs, newDiscardLogger())
 
-		result, err := linker.NeedBackup(c.dest)
+		result, err := linker.ContentState(t.Context(), c.wantSum, c.dest, c.recordedSum)
 
+		assert.NoError(t, err)
 		assert.Equal(t, c.expectedResult, result)
-		assert.Equal(t, c.expectedError, err)
 	}
 }
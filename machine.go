@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+)
+
+// Machine describes the machine dotbro runs on.
+type Machine struct {
+	Hostname string
+	OS       string
+	Arch     string
+	Username string
+}
+
+// CurrentMachine returns the description of the current machine.
+// Facts that cannot be determined are left empty.
+func CurrentMachine() Machine {
+	m := Machine{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		m.Hostname = hostname
+	}
+
+	if u, err := user.Current(); err == nil {
+		m.Username = u.Username
+	} else {
+		m.Username = os.Getenv("USER")
+	}
+
+	return m
+}
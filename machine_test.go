@@ -0,0 +1,18 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrentMachine(t *testing.T) {
+	t.Parallel()
+
+	m := CurrentMachine()
+
+	assert.Equal(t, runtime.GOOS, m.OS)
+	assert.Equal(t, runtime.GOARCH, m.Arch)
+	assert.NotEmpty(t, m.Hostname)
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 const logFilepath = "${HOME}/.dotbro/dotbro.log"
@@ -17,8 +26,21 @@ var (
 
 // App is the main application structure.
 type App struct {
-	logger  *slog.Logger
-	profile *Profile
+	logger   *slog.Logger
+	in       *bufio.Reader
+	out      io.Writer
+	profile  *Profile
+	manifest *Manifest
+	backups  *BackupGeneration
+
+	// machine describes the current machine for templates.
+	machine Machine
+
+	// conflict is the conflict policy that overrides the profile settings.
+	conflict string
+
+	// dryRun makes actions only show what they would do.
+	dryRun bool
 }
 
 func main() {
@@ -40,8 +62,19 @@ func main() {
 		logLevel = slog.LevelInfo
 	}
 
+	conflict, _ := args["--conflict"].(string)
+	if err = CheckConflictPolicy(conflict); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing arguments: %s\n", err)
+		os.Exit(1)
+	}
+
 	app := &App{
-		logger: newConsoleLogger(logLevel),
+		logger:   newConsoleLogger(logLevel),
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		machine:  CurrentMachine(),
+		conflict: conflict,
+		dryRun:   args["--dry-run"].(bool),
 	}
 	app.Run(args)
 }
@@ -53,188 +86,1167 @@ func (app *App) Run(args map[string]any) {
 	app.logger.DebugContext(ctx, "Start")
 	app.logger.DebugContext(ctx, "Arguments passed", slog.Any("args", args))
 
-	// Process profiles
-	profilePaths := app.getProfilePaths(ctx, args["--config"])
+	profilePaths := app.chooseProfilePaths(ctx, args)
+
+	// Select action
+	switch {
+	case args["add"] == true:
+		app.runAdd(ctx, args, profilePaths)
+	case args["clean"] == true:
+		app.forEachProfile(ctx, args, profilePaths, "Clean", app.cleanAction)
+		app.logger.InfoContext(ctx, "Cleaned!")
+	case args["status"] == true:
+		app.runStatus(ctx, args, profilePaths)
+	case args["restore"] == true:
+		app.runRestore(ctx, args, profilePaths)
+	case args["adopt"] == true:
+		app.runAdopt(ctx, args, profilePaths)
+	case args["check"] == true:
+		app.runCheck(ctx, args, profilePaths)
+	case args["forget"] == true:
+		app.runForget(ctx, args, profilePaths)
+	case args["uninstall"] == true:
+		app.forEachProfile(ctx, args, profilePaths, "Uninstall", app.uninstallAction)
+	default:
+		// Default action: install
+		app.forEachProfile(ctx, args, profilePaths, "Install", app.installAction)
+	}
+
+	app.logger.InfoContext(ctx, "All done (─‿‿─)")
+	app.exit(0)
+}
 
+// chooseProfilePaths returns the paths of the profiles the command runs for.
+func (app *App) chooseProfilePaths(ctx context.Context, args map[string]any) []string {
+	profilePaths := app.getProfilePaths(ctx, args["--config"], app.readOnly(args))
+
+	var err error
+	if selector, ok := args["--profile"].(string); ok {
+		if profilePaths, err = selectProfilePaths(profilePaths, selector); err != nil {
+			app.logger.ErrorContext(ctx, "Cannot choose profile", slog.Any("error", err))
+			app.exit(1)
+		}
+	}
+	if args["add"] == true {
+		if profilePaths, err = addProfilePaths(profilePaths, args["<filename>"].(string)); err != nil {
+			app.logger.ErrorContext(ctx, "Cannot choose profile", slog.Any("error", err))
+			app.exit(1)
+		}
+	}
+	return profilePaths
+}
+
+// forEachProfile loads the profiles one by one and runs the action for each
+// of them. A failed action stops dotbro; name is the action name to report.
+func (app *App) forEachProfile(ctx context.Context, args map[string]any, profilePaths []string, name string, action func(context.Context) error) {
 	for _, profilePath := range profilePaths {
-		app.logger.DebugContext(ctx, "Loading profile", slog.String("path", profilePath))
-		var err error
-		app.profile, err = NewProfile(profilePath)
-		if err != nil {
+		if err := app.loadProfile(ctx, profilePath); err != nil {
 			app.logger.ErrorContext(ctx, "Cannot read profile", slog.String("path", profilePath), slog.Any("error", err))
 			app.logger.InfoContext(ctx, "Maybe you have renamed your profile file?\nIf so, run dotbro with '--config' argument (see 'dotbro --help' for details).", slog.String("tip", "TIP"))
 			app.exit(1)
 		}
+		app.prepareProfile(ctx, profilePath, app.readOnly(args))
 
-		// Preparations
-		err = os.MkdirAll(app.profile.BackupDir(), 0700)
+		if err := action(ctx); err != nil {
+			app.logger.ErrorContext(ctx, name+" action failed", slog.Any("error", err))
+			app.exit(1)
+		}
+	}
+}
+
+// loadProfile makes the profile at profilePath the current one.
+func (app *App) loadProfile(ctx context.Context, profilePath string) error {
+	app.logger.DebugContext(ctx, "Loading profile", slog.String("path", profilePath))
+	profile, err := NewProfile(profilePath)
+	if err != nil {
+		return err
+	}
+	app.profile = profile
+	return nil
+}
+
+// prepareProfile loads the manifest of the current profile and starts a new
+// backup generation. Unless the command is read-only, it creates the backup
+// directory too.
+func (app *App) prepareProfile(ctx context.Context, profilePath string, readOnly bool) {
+	app.manifest = NewManifest(app.logger, ManifestFilepath(defaultManifestDir, profilePath), profilePath)
+	if err := app.manifest.Load(ctx); err != nil {
+		app.logger.ErrorContext(ctx, "Cannot read manifest", slog.Any("error", err))
+		app.exit(1)
+	}
+
+	app.backups = NewBackupGeneration(app.profile.BackupDir(), time.Now())
+
+	if !readOnly {
+		err := os.MkdirAll(app.profile.BackupDir(), 0700)
 		if err != nil && !os.IsExist(err) {
 			app.logger.ErrorContext(ctx, "Error creating backup directory", slog.Any("error", err))
 			app.exit(1)
 		}
+	}
 
-		app.logger.DebugContext(ctx, "Profile directories",
-			slog.String("dotfiles", app.profile.DotfilesDir()),
-			slog.String("sources", app.profile.SourcesDir()),
-			slog.String("destination", app.profile.DestinationDir()),
-			slog.String("backup", app.profile.BackupDir()))
+	app.logger.DebugContext(ctx, "Profile directories",
+		slog.String("dotfiles", app.profile.DotfilesDir()),
+		slog.String("sources", app.profile.SourcesDir()),
+		slog.String("destination", app.profile.DestinationDir()),
+		slog.String("backup", app.profile.BackupDir()))
+}
 
-		// Select action
-		switch {
-		case args["add"]:
-			// TODO: add support for multiple configs
-			filename := args["<filename>"].(string)
-			if err = app.addAction(ctx, filename); err != nil {
-				app.logger.ErrorContext(ctx, "Add action failed", slog.Any("error", err))
-				app.exit(1)
+// runAdd runs the add command.
+func (app *App) runAdd(ctx context.Context, args map[string]any, profilePaths []string) {
+	filename := args["<filename>"].(string)
+	as, _ := args["--as"].(string)
+	app.forEachProfile(ctx, args, profilePaths, "Add", func(ctx context.Context) error {
+		return app.addAction(ctx, filename, as)
+	})
+
+	app.logger.InfoContext(ctx, "File was successfully added to your dotfiles!", slog.String("path", filename))
+}
+
+// runStatus runs the status command and exits with a non-zero code if
+// anything is out of sync.
+func (app *App) runStatus(ctx context.Context, args map[string]any, profilePaths []string) {
+	outOfSync := false
+	app.forEachProfile(ctx, args, profilePaths, "Status", func(ctx context.Context) error {
+		inSync, err := app.statusAction(ctx)
+		outOfSync = outOfSync || !inSync
+		return err
+	})
+
+	if outOfSync {
+		app.logger.WarnContext(ctx, "Dotfiles are out of sync")
+		app.exit(1)
+	}
+}
+
+// runRestore runs the restore command. Backups are restored from the first
+// profile that has them, and listed once for every backup directory.
+func (app *App) runRestore(ctx context.Context, args map[string]any, profilePaths []string) {
+	if args["--list"].(bool) {
+		listed := make(map[string]bool)
+		app.forEachProfile(ctx, args, profilePaths, "Listing backups", func(context.Context) error {
+			if listed[app.profile.BackupDir()] {
+				return nil
+			}
+			listed[app.profile.BackupDir()] = true
+			return app.listBackupsAction()
+		})
+		return
+	}
+
+	restored := false
+	app.forEachProfile(ctx, args, profilePaths, "Restore", func(ctx context.Context) error {
+		if restored {
+			return nil
+		}
+		var err error
+		restored, err = app.restoreAction(ctx, args["<filename>"], args["--run"])
+		return err
+	})
+
+	if !restored {
+		app.logger.ErrorContext(ctx, "No backup found to restore")
+		app.exit(1)
+	}
+}
+
+// runAdopt runs the adopt command and fails if no profile maps the file.
+func (app *App) runAdopt(ctx context.Context, args map[string]any, profilePaths []string) {
+	adopted := false
+	app.forEachProfile(ctx, args, profilePaths, "Adopt", func(ctx context.Context) error {
+		found, err := app.adoptAction(ctx, args["<filename>"])
+		adopted = adopted || found
+		return err
+	})
+
+	if !adopted {
+		app.logger.ErrorContext(ctx, "No mapping entry found for the file", slog.Any("path", args["<filename>"]))
+		app.exit(1)
+	}
+}
+
+// runCheck runs the check command. A profile that cannot be loaded is
+// reported like other problems instead of stopping dotbro.
+func (app *App) runCheck(ctx context.Context, args map[string]any, profilePaths []string) {
+	invalid := false
+	for _, profilePath := range profilePaths {
+		if err := app.loadProfile(ctx, profilePath); err != nil {
+			diagnostic := Diagnostic{File: profilePath, Message: err.Error()}
+			var profileErr *ProfileError
+			if errors.As(err, &profileErr) {
+				diagnostic = profileErr.Diagnostic()
+			}
+			fmt.Fprintln(app.out, diagnostic)
+			invalid = true
+			continue
+		}
+		app.prepareProfile(ctx, profilePath, app.readOnly(args))
+
+		valid, err := app.checkAction(ctx)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "Check action failed", slog.Any("error", err))
+			app.exit(1)
+		}
+		invalid = invalid || !valid
+	}
+
+	if invalid {
+		app.logger.ErrorContext(ctx, "Profile has problems")
+		app.exit(1)
+	}
+}
+
+// runForget runs the forget command and fails if no profile maps the file.
+func (app *App) runForget(ctx context.Context, args map[string]any, profilePaths []string) {
+	forgotten := false
+	app.forEachProfile(ctx, args, profilePaths, "Forget", func(ctx context.Context) error {
+		found, err := app.forgetAction(ctx, args["<filename>"].(string), args["--delete-source"].(bool))
+		forgotten = forgotten || found
+		return err
+	})
+
+	if !forgotten {
+		app.logger.ErrorContext(ctx, "No mapping entry found for the file", slog.Any("path", args["<filename>"]))
+		app.exit(1)
+	}
+}
+
+func (app *App) addAction(ctx context.Context, filename, as string) error {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := os.Lstat(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s: no such file or directory", filename)
+		}
+		return err
+	}
+
+	if fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
+		return fmt.Errorf("Cannot add file %s - it is a symlink", filename)
+	}
+
+	srcDirAbs, err := app.sourcesDirAbs()
+	if err != nil {
+		return err
+	}
+
+	target, err := app.addTarget(filename, as)
+	if err != nil {
+		return err
+	}
+
+	newPath := filepath.Join(srcDirAbs, target)
+	if !IsInside(srcDirAbs, newPath) || newPath == srcDirAbs {
+		return fmt.Errorf("Cannot add file %s - %s is outside of the dotfiles directory", filename, newPath)
+	}
+	if IsInside(filename, srcDirAbs) {
+		return fmt.Errorf("Cannot add file %s - it contains the dotfiles directory", filename)
+	}
+	if _, err = os.Lstat(newPath); err == nil {
+		return fmt.Errorf("Cannot add file %s - %s already exists", filename, newPath)
+	}
+
+	app.logger.DebugContext(ctx, "Adding file to dotfiles directory",
+		slog.String("src", filename),
+		slog.String("dst", newPath))
+
+	// Keep a backup copy of the file or the directory, move it to the dotfiles
+	// directory and put a symlink in its place.
+	var plan Plan
+	plan.Add(StepBackupCopy, filename, app.backups.PathFor(filename, app.profile.DestinationDir()))
+	plan.Add(StepAdopt, filename, newPath)
+	plan.AddSymlink(newPath, filename, app.profile.RelativeLinks())
+
+	addToProfile := func() error {
+		return app.addToProfile(ctx, newPath, filename, srcDirAbs)
+	}
+
+	if app.dryRun {
+		app.logDryRun(ctx, plan)
+		return addToProfile()
+	}
+
+	return app.executePlan(ctx, plan, addToProfile)
+}
+
+// addTarget returns the path inside the sources directory the file is moved to.
+// By default, it is the path of the file relative to the destination directory,
+// so files with the same name in different directories do not collide.
+func (app *App) addTarget(filename, as string) (string, error) {
+	if as != "" {
+		if filepath.IsAbs(as) {
+			return "", fmt.Errorf("Cannot add file %s - path %s must be relative to the dotfiles directory", filename, as)
+		}
+		return filepath.Clean(as), nil
+	}
+
+	if !IsInside(app.profile.DestinationDir(), filename) {
+		return filepath.Base(filename), nil
+	}
+	return filepath.Rel(app.profile.DestinationDir(), filename)
+}
+
+// addToProfile adds the mapping entry for the added file to the profile file.
+// A file outside of the destination directory is mapped through a root
+// containing it, or by its absolute path.
+func (app *App) addToProfile(ctx context.Context, srcAbs, destAbs, srcDirAbs string) error {
+	src, err := filepath.Rel(srcDirAbs, srcAbs)
+	if err != nil {
+		return err
+	}
+	dest := app.profile.MappingDestination(destAbs)
+
+	mapping := app.profile.Data().Mapping
+	if len(mapping) == 0 {
+		if src == dest {
+			// The file is installed as is without mapping.
+			return nil
+		}
+		app.logger.WarnContext(ctx, "Profile has no mapping and installs all files as is, add the file to the profile manually",
+			slog.String("src", src),
+			slog.String("dst", dest))
+		return nil
+	}
+
+	if entry, ok := mapping[src]; ok {
+		if app.profile.DestinationPath(entry.Destination) != destAbs {
+			app.logger.WarnContext(ctx, "Source is already mapped to another destination, fix the profile manually",
+				slog.String("src", src),
+				slog.String("dst", entry.Destination))
+		}
+		return nil
+	}
+
+	attrs := []any{
+		slog.String("src", src),
+		slog.String("dst", dest),
+		slog.String("profile", app.profile.Filepath()),
+	}
+
+	if app.dryRun {
+		app.logger.InfoContext(ctx, "add mapping", append([]any{slog.String("status", "dry-run")}, attrs...)...)
+		return nil
+	}
+
+	if err = AddProfileMapping(app.profile.Filepath(), src, dest); err != nil {
+		return fmt.Errorf("Cannot add mapping to profile %s: %s", app.profile.Filepath(), err)
+	}
+	app.logger.InfoContext(ctx, "add mapping", append([]any{slog.String("status", "+")}, attrs...)...)
+
+	return nil
+}
+
+// adoptAction moves existing destination files into the dotfiles directory
+// in place of their sources and installs them back from there. If filenameArg
+// is set, only the mapping entry with this destination is adopted. It returns
+// false if the current profile has no such entry.
+func (app *App) adoptAction(ctx context.Context, filenameArg any) (bool, error) {
+	app.conflict = ConflictAdopt
+
+	filename, ok := filenameArg.(string)
+	if !ok {
+		return true, app.installAction(ctx)
+	}
+
+	destAbs, err := filepath.Abs(filename)
+	if err != nil {
+		return false, err
+	}
+
+	srcDirAbs, err := app.sourcesDirAbs()
+	if err != nil {
+		return false, err
+	}
+
+	var plan Plan
+	found := false
+	linker := NewLinker(osfs, app.logger)
+	for src, entry := range app.getMapping(ctx, srcDirAbs) {
+		if app.profile.DestinationPath(entry.Destination) != destAbs {
+			continue
+		}
+		found = true
+		if err = app.planDotfile(ctx, &plan, linker, src, entry, srcDirAbs); err != nil {
+			return false, err
+		}
+	}
+
+	if !found || plan.Empty() {
+		return found, nil
+	}
+
+	if app.dryRun {
+		app.logDryRun(ctx, plan)
+		return true, nil
+	}
+
+	return true, app.executePlan(ctx, plan, nil)
+}
+
+// checkAction prints problems found in the profile.
+// It reports whether the profile has no problems.
+func (app *App) checkAction(ctx context.Context) (bool, error) {
+	diagnostics, err := CheckProfile(app.profile)
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range diagnostics {
+		fmt.Fprintln(app.out, d)
+	}
+	if len(diagnostics) == 0 {
+		app.logger.InfoContext(ctx, "Profile is valid", slog.String("profile", app.profile.Filepath()))
+	}
+	return len(diagnostics) == 0, nil
+}
+
+// forgetAction stops managing the destination file: the symlink is replaced
+// with a copy of the source and the mapping entry is removed from the profile.
+// It reports whether the file is mapped by the current profile.
+func (app *App) forgetAction(ctx context.Context, filename string, deleteSource bool) (bool, error) {
+	destAbs, err := filepath.Abs(filename)
+	if err != nil {
+		return false, err
+	}
+
+	srcDirAbs, err := app.sourcesDirAbs()
+	if err != nil {
+		return false, err
+	}
+
+	var src string
+	var entry MappingEntry
+	found := false
+	for s, e := range app.getMapping(ctx, srcDirAbs) {
+		if app.profile.DestinationPath(e.Destination) == destAbs {
+			src, entry, found = s, e, true
+			break
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	srcAbs := path.Join(srcDirAbs, src)
+	if _, err = os.Stat(srcAbs); err != nil {
+		return true, fmt.Errorf("Cannot forget file %s: %s", destAbs, err)
+	}
+
+	var plan Plan
+	if !entry.HasContent() {
+		// Copies and rendered templates are real files already.
+		if err = app.planForget(ctx, &plan, NewLinker(osfs, app.logger), srcAbs, destAbs); err != nil {
+			return true, err
+		}
+	}
+	if deleteSource {
+		plan.Add(StepDelete, "", srcAbs)
+	}
+
+	if app.dryRun {
+		app.logDryRun(ctx, plan)
+		return true, app.forgetMapping(ctx, src)
+	}
+
+	// The profile is edited before the changes are committed, so a failed
+	// edit rolls them back.
+	return true, app.executePlan(ctx, plan, func() error {
+		if err := app.forgetMapping(ctx, src); err != nil {
+			return err
+		}
+		app.manifest.RemoveLink(destAbs)
+		return nil
+	})
+}
+
+// planForget adds steps needed to replace the symlink to the source with a
+// copy of the source to the plan. An unfolded directory is handled entry by entry.
+func (app *App) planForget(ctx context.Context, plan *Plan, linker Linker, srcAbs, destAbs string) error {
+	unfolded, err := linker.Unfolded(srcAbs, destAbs)
+	if err != nil {
+		return err
+	}
+	if unfolded {
+		entries, err := os.ReadDir(srcAbs)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			err = app.planForget(ctx, plan, linker, path.Join(srcAbs, e.Name()), path.Join(destAbs, e.Name()))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	state, err := linker.State(ctx, srcAbs, destAbs)
+	if err != nil {
+		return err
+	}
+	switch state {
+	case DestLinked:
+		plan.Add(StepUnlink, "", destAbs)
+	case DestMissing:
+	default:
+		app.logger.DebugContext(ctx, "Destination is not linked, keeping it", slog.String("path", destAbs))
+		return nil
+	}
+
+	files, err := CopyPairs(srcAbs, destAbs)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		plan.AddCopy(file.Src, file.Dst, "")
+	}
+	return nil
+}
+
+// forgetMapping removes the mapping entry of the forgotten file from the profile file.
+func (app *App) forgetMapping(ctx context.Context, src string) error {
+	origin, key := app.profile.Origin(src)
+	if origin != "" && origin != app.profile.Filepath() {
+		app.logger.WarnContext(ctx, "File is mapped by an included profile, remove it there or add a removal entry to the profile",
+			slog.String("src", src),
+			slog.String("profile", origin))
+		return nil
+	}
+
+	if _, ok := app.profile.Data().Mapping[src]; !ok {
+		if len(app.profile.Data().Mapping) == 0 {
+			app.logger.WarnContext(ctx, "Profile has no mapping and installs all files, add the file to excludes or delete it",
+				slog.String("src", src))
+		} else {
+			app.logger.WarnContext(ctx, "File is mapped by a pattern, fix the profile manually",
+				slog.String("src", src))
+		}
+		return nil
+	}
+
+	attrs := []any{
+		slog.String("src", src),
+		slog.String("profile", app.profile.Filepath()),
+	}
+
+	if app.dryRun {
+		app.logger.InfoContext(ctx, "remove mapping", append([]any{slog.String("status", "dry-run")}, attrs...)...)
+		return nil
+	}
+
+	if err := RemoveProfileMapping(app.profile.Filepath(), key); err != nil {
+		return fmt.Errorf("Cannot remove mapping from profile %s: %s", app.profile.Filepath(), err)
+	}
+	app.logger.InfoContext(ctx, "remove mapping", append([]any{slog.String("status", "-")}, attrs...)...)
+
+	if len(app.profile.Data().Mapping) == 1 {
+		app.logger.WarnContext(ctx, "Profile mapping is empty now, so all files in the dotfiles directory will be installed",
+			slog.String("profile", app.profile.Filepath()))
+	}
+	return nil
+}
+
+func (app *App) cleanAction(ctx context.Context) error {
+	var plan Plan
+	if err := app.planDeadSymlinks(&plan); err != nil {
+		return err
+	}
+
+	if app.dryRun {
+		plan.Log(ctx, app.logger)
+		return nil
+	}
+
+	if !plan.Empty() {
+		app.logger.InfoContext(ctx, "Cleaning dead symlinks...")
+	}
+
+	return app.executePlan(ctx, plan, nil)
+}
+
+func (app *App) installAction(ctx context.Context) error {
+	plan, err := app.planInstall(ctx)
+	if err != nil {
+		return err
+	}
+
+	if plan.Empty() {
+		return nil
+	}
+
+	if app.dryRun {
+		app.logDryRun(ctx, plan)
+		return nil
+	}
+
+	app.logger.InfoContext(ctx, "Installing dotfiles",
+		slog.String("src", app.profile.DotfilesDir()),
+		slog.String("dst", app.profile.DestinationDir()))
+
+	return app.executePlan(ctx, plan, nil)
+}
+
+// executePlan carries out the plan and records the performed steps in the manifest
+// and the backup index. finish, if not nil, is called before the changes are
+// committed, and its failure rolls the plan back.
+// If the plan fails, it is rolled back and nothing is recorded.
+func (app *App) executePlan(ctx context.Context, plan Plan, finish func() error) error {
+	err := plan.Execute(ctx, NewLinker(osfs, app.logger), app.logger, finish, func(step Step) {
+		app.manifest.Record(step)
+		if step.Kind == StepBackup || step.Kind == StepBackupCopy {
+			app.backups.Add(step.Src, step.Dst)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// The changes are on disk already, so only the manifest is essential.
+	if err = app.backups.Save(); err != nil {
+		app.logger.WarnContext(ctx, "Cannot save backup index", slog.Any("error", err))
+	}
+	if len(app.backups.Entries) > 0 {
+		app.logger.InfoContext(ctx, "Original files were backed up", slog.String("path", app.backups.Dir()))
+	}
+
+	if err = app.manifest.Save(ctx); err != nil {
+		app.logger.ErrorContext(ctx, "Cannot save manifest", slog.Any("error", err))
+		return err
+	}
+
+	return nil
+}
+
+// logDryRun shows what the plan would do instead of carrying it out.
+func (app *App) logDryRun(ctx context.Context, plan Plan) {
+	app.logger.InfoContext(ctx, "Dry run, nothing will be changed", slog.String("profile", app.profile.Filepath()))
+	plan.Log(ctx, app.logger)
+}
+
+// planInstall builds a plan of the install action for the current profile.
+func (app *App) planInstall(ctx context.Context) (Plan, error) {
+	var plan Plan
+	if err := app.planDeadSymlinks(&plan); err != nil {
+		return Plan{}, err
+	}
+
+	srcDirAbs, err := app.sourcesDirAbs()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	mapping := app.getMapping(ctx, srcDirAbs)
+	linker := NewLinker(osfs, app.logger)
+
+	app.logger.InfoContext(ctx, "--> Installing dotfiles...", slog.String("profile", app.profile.Filepath()))
+
+	// Sort sources to get the same plan on every run.
+	srcs := make([]string, 0, len(mapping))
+	for src := range mapping {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	for _, src := range srcs {
+		if err = app.planDotfile(ctx, &plan, linker, src, mapping[src], srcDirAbs); err != nil {
+			return Plan{}, err
+		}
+	}
+
+	return plan, nil
+}
+
+// planDeadSymlinks adds removal of dead symlinks in the destination directory to the plan.
+func (app *App) planDeadSymlinks(plan *Plan) error {
+	cleaner := NewCleaner(osfs, app.logger)
+	deadSymlinks, err := cleaner.FindDeadSymlinks(app.profile.DestinationDir())
+	if err != nil {
+		return fmt.Errorf("Error cleaning dead symlinks: %s", err)
+	}
+
+	for _, p := range deadSymlinks {
+		plan.Add(StepRemoveDeadSymlink, "", p)
+	}
+
+	return nil
+}
+
+// sourcesDirAbs returns the absolute path of the directory mapping sources are relative to.
+func (app *App) sourcesDirAbs() (string, error) {
+	srcDirAbs := app.profile.DotfilesDir()
+	if app.profile.SourcesDir() == "" {
+		return srcDirAbs, nil
+	}
+
+	srcDirAbs += "/" + app.profile.SourcesDir()
+	if _, err := os.Stat(srcDirAbs); os.IsNotExist(err) {
+		return "", fmt.Errorf("Sources directory `%s' does not exist.", app.profile.SourcesDir())
+	} else if err != nil {
+		return "", fmt.Errorf("Error reading sources directory `%s': %s", app.profile.SourcesDir(), err)
+	}
+
+	return srcDirAbs, nil
+}
+
+// statusAction reports the state of every mapping entry of the current profile.
+// It returns false if any entry is not installed correctly.
+func (app *App) statusAction(ctx context.Context) (bool, error) {
+	srcDirAbs, err := app.sourcesDirAbs()
+	if err != nil {
+		return false, err
+	}
+
+	mapping := app.getMapping(ctx, srcDirAbs)
+	linker := NewLinker(osfs, app.logger)
+
+	srcs := make([]string, 0, len(mapping))
+	for src := range mapping {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	fmt.Fprintf(app.out, "%s:\n", app.profile.Filepath())
+	w := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+
+	inSync := true
+	for _, src := range srcs {
+		entry := mapping[src]
+		srcAbs := path.Join(srcDirAbs, src)
+		destAbs := app.profile.DestinationPath(entry.Destination)
+
+		var status string
+		if _, err = osfs.Stat(srcAbs); osfs.IsNotExist(err) {
+			status = "source missing"
+			inSync = false
+		} else if err != nil {
+			return false, fmt.Errorf("Error processing source file %s: %s", src, err)
+		} else {
+			state, err := app.entryState(ctx, linker, srcAbs, destAbs, entry)
+			if err != nil {
+				return false, fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+			}
+			status = state.String()
+			inSync = inSync && (state == DestLinked || state == DestUpToDate)
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t→ %s\n", status, entry.Destination, src)
+	}
+
+	return inSync, w.Flush()
+}
+
+// entryState reports the state of the mapping entry destination.
+// For a copied or rendered directory, the state of the first file that is not
+// up to date is reported.
+func (app *App) entryState(ctx context.Context, linker Linker, srcAbs, destAbs string, entry MappingEntry) (DestState, error) {
+	if !entry.HasContent() {
+		return app.linkState(ctx, linker, srcAbs, destAbs)
+	}
+
+	files, err := CopyPairs(srcAbs, destAbs)
+	if err != nil {
+		return DestMissing, err
+	}
+
+	for _, file := range files {
+		if file.Dst != destAbs {
+			// Files of a directory symlink must not be mistaken for copies.
+			if fi, err := osfs.Lstat(destAbs); err == nil && fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+				return DestWrongLink, nil
+			}
+		}
+
+		_, wantSum, err := app.sourceContent(file.Src, entry.InstallMode())
+		if err != nil {
+			return DestMissing, err
+		}
+
+		state, err := linker.ContentState(ctx, wantSum, file.Dst, app.recordedChecksum(file.Dst))
+		if err != nil {
+			return DestMissing, err
+		}
+		if state != DestUpToDate {
+			return state, nil
+		}
+	}
+
+	return DestUpToDate, nil
+}
+
+// linkState reports the state of the symlink destination. An unfolded
+// directory is linked when every entry of the source directory is linked.
+func (app *App) linkState(ctx context.Context, linker Linker, srcAbs, destAbs string) (DestState, error) {
+	state, err := linker.State(ctx, srcAbs, destAbs)
+	if err != nil || state != DestBlocked {
+		return state, err
+	}
+
+	unfolded, err := linker.Unfolded(srcAbs, destAbs)
+	if err != nil || !unfolded {
+		return state, err
+	}
+
+	entries, err := os.ReadDir(srcAbs)
+	if err != nil {
+		return DestMissing, err
+	}
+	for _, entry := range entries {
+		state, err = app.linkState(ctx, linker, path.Join(srcAbs, entry.Name()), path.Join(destAbs, entry.Name()))
+		if err != nil || state != DestLinked {
+			return state, err
+		}
+	}
+
+	return DestLinked, nil
+}
+
+// listBackupsAction prints all backup generations of the current profile.
+func (app *App) listBackupsAction() error {
+	generations, err := LoadBackupGenerations(app.profile.BackupDir())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.out, "%s:\n", app.profile.BackupDir())
+	if len(generations) == 0 {
+		fmt.Fprintln(app.out, "  no backups")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(app.out, 0, 0, 2, ' ', 0)
+	for _, g := range generations {
+		fmt.Fprintf(w, "  %s\t%s\n", g.ID, g.Time.Local().Format(time.DateTime))
+		for _, entry := range g.Entries {
+			status := "available"
+			if _, err = osfs.Lstat(g.BackupPath(entry)); err != nil {
+				status = "gone"
+			}
+			fmt.Fprintf(w, "    %s\t%s\n", status, entry.Original)
+		}
+	}
+
+	return w.Flush()
+}
+
+// restoreAction moves backed up files back to their original paths.
+// Either a single file or a whole backup generation (run) is restored.
+// It returns false if the current profile has no backup to restore.
+func (app *App) restoreAction(ctx context.Context, filenameArg, runArg any) (bool, error) {
+	var plan Plan
+
+	if runArg != nil {
+		generations, err := LoadBackupGenerations(app.profile.BackupDir())
+		if err != nil {
+			return false, err
+		}
+
+		var generation *BackupGeneration
+		for _, g := range generations {
+			if g.ID == runArg.(string) {
+				generation = g
+			}
+		}
+		if generation == nil {
+			return false, nil
+		}
+
+		for _, entry := range generation.Entries {
+			backup := generation.BackupPath(entry)
+			if _, err = osfs.Lstat(backup); err != nil {
+				app.logger.WarnContext(ctx, "Backup is gone, skipping", slog.String("path", backup))
+				continue
+			}
+			if err = app.planRestore(ctx, &plan, backup, entry.Original); err != nil {
+				return false, err
 			}
+		}
+	} else {
+		original, err := filepath.Abs(filenameArg.(string))
+		if err != nil {
+			return false, err
+		}
+
+		backup, ok := app.findBackup(original)
+		if !ok {
+			return false, nil
+		}
+		if err = app.planRestore(ctx, &plan, backup, original); err != nil {
+			return false, err
+		}
+	}
+
+	if app.dryRun {
+		app.logDryRun(ctx, plan)
+		return true, nil
+	}
+
+	app.logger.InfoContext(ctx, "--> Restoring backups...", slog.String("profile", app.profile.Filepath()))
+
+	return true, app.executePlan(ctx, plan, nil)
+}
+
+// planRestore adds steps that move the backup to the original path to the plan.
+// A dotbro symlink at the original path is removed first. Any other file there
+// is never replaced.
+func (app *App) planRestore(ctx context.Context, plan *Plan, backup, original string) error {
+	_, err := osfs.Lstat(original)
+	if osfs.IsNotExist(err) {
+		plan.Add(StepRestore, backup, original)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	removed, err := app.planRemoveManaged(ctx, plan, original)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("Cannot restore %s: the path is occupied by a file not managed by dotbro", original)
+	}
+
+	plan.Add(StepRestore, backup, original)
+	return nil
+}
+
+func (app *App) uninstallAction(ctx context.Context) error {
+	plan, err := app.planUninstall(ctx)
+	if err != nil {
+		return err
+	}
+
+	if plan.Empty() {
+		app.logger.InfoContext(ctx, "Nothing to uninstall", slog.String("profile", app.profile.Filepath()))
+		return nil
+	}
+
+	if app.dryRun {
+		app.logDryRun(ctx, plan)
+		return nil
+	}
+
+	app.logger.InfoContext(ctx, "--> Uninstalling dotfiles...", slog.String("profile", app.profile.Filepath()))
+
+	return app.executePlan(ctx, plan, nil)
+}
+
+// planUninstall builds a plan that removes symlinks managed by the current profile
+// and moves backed up original files back.
+// Only symlinks pointing inside the dotfiles directory are considered managed.
+func (app *App) planUninstall(ctx context.Context) (Plan, error) {
+	dests := make(map[string]struct{})
+	for _, link := range app.manifest.Links() {
+		dests[link.Destination] = struct{}{}
+	}
+
+	srcDirAbs, err := app.sourcesDirAbs()
+	if err == nil {
+		for _, entry := range app.getMapping(ctx, srcDirAbs) {
+			dests[app.profile.DestinationPath(entry.Destination)] = struct{}{}
+		}
+	} else {
+		app.logger.WarnContext(ctx, "Cannot read mapping, using only the manifest", slog.Any("error", err))
+	}
+
+	sorted := make([]string, 0, len(dests))
+	for dest := range dests {
+		sorted = append(sorted, dest)
+	}
+	sort.Strings(sorted)
 
-			app.logger.InfoContext(ctx, "File was successfully added to your dotfiles!", slog.String("path", filename))
-			app.logger.InfoContext(ctx, "All done (─‿‿─)")
-			app.exit(0)
-		case args["clean"]:
-			// TODO: add support for multiple configs
-			if err = app.cleanAction(ctx); err != nil {
-				app.logger.ErrorContext(ctx, "Clean action failed", slog.Any("error", err))
-				app.exit(1)
-			}
+	var plan Plan
+	for _, dest := range sorted {
+		removed, err := app.planRemoveManaged(ctx, &plan, dest)
+		if err != nil {
+			return Plan{}, err
+		}
+		if !removed {
+			app.logger.DebugContext(ctx, "Not managed by dotbro, skipping", slog.String("path", dest))
+			continue
+		}
 
-			app.logger.InfoContext(ctx, "Cleaned!")
-			app.logger.InfoContext(ctx, "All done (─‿‿─)")
-			app.exit(0)
-		default:
-			// Default action: install
-			if err = app.installAction(ctx); err != nil {
-				app.logger.ErrorContext(ctx, "Install action failed", slog.Any("error", err))
-				app.exit(1)
-			}
+		if backup, ok := app.findBackup(dest); ok {
+			plan.Add(StepRestore, backup, dest)
 		}
 	}
 
-	app.logger.InfoContext(ctx, "All done (─‿‿─)")
-	app.exit(0)
+	return plan, nil
 }
 
-func (app *App) addAction(ctx context.Context, filename string) error {
-	fileInfo, err := os.Lstat(filename)
+// planRemoveManaged adds removal of the symlink or the copy at dest to the plan,
+// if it is managed by the current profile. It reports whether the removal was planned.
+// A copy is removed only if it was not modified since dotbro made it.
+func (app *App) planRemoveManaged(ctx context.Context, plan *Plan, dest string) (bool, error) {
+	fi, err := osfs.Lstat(dest)
+	if osfs.IsNotExist(err) {
+		return false, nil
+	}
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("%s: no such file or directory", filename)
+		return false, err
+	}
+
+	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		managed, err := app.isManagedSymlink(dest)
+		if err != nil || !managed {
+			return false, err
 		}
-		return err
+		plan.Add(StepUnlink, "", dest)
+		return true, nil
 	}
 
-	if fileInfo.Mode()&os.ModeSymlink == os.ModeSymlink {
-		return fmt.Errorf("Cannot add file %s - it is a symlink", filename)
+	link, ok := app.manifest.Link(dest)
+	if !ok || link.Checksum == "" || !fi.Mode().IsRegular() {
+		return false, nil
 	}
 
-	if fileInfo.Mode().IsDir() {
-		return fmt.Errorf("Cannot add dir %s - directories are not supported yet.", filename)
+	sum, err := Checksum(osfs, dest)
+	if err != nil {
+		return false, err
+	}
+	if sum != link.Checksum {
+		app.logger.WarnContext(ctx, "Copy was modified since it was installed, keeping it", slog.String("path", dest))
+		return false, nil
 	}
 
-	app.logger.DebugContext(ctx, "Adding file to dotfiles root",
-		slog.String("src", filename),
-		slog.String("dst", app.profile.DotfilesDir()))
+	plan.Add(StepRemoveCopy, "", dest)
+	return true, nil
+}
 
-	// backup file
-	backupPath := app.profile.BackupDir() + "/" + path.Base(filename)
-	if err = Copy(osfs, filename, backupPath); err != nil {
-		return fmt.Errorf("Cannot backup file %s: %s", filename, err)
+// isManagedSymlink reports whether dest is a symlink pointing inside the dotfiles directory.
+func (app *App) isManagedSymlink(dest string) (bool, error) {
+	fi, err := osfs.Lstat(dest)
+	if osfs.IsNotExist(err) {
+		return false, nil
 	}
-	app.logger.InfoContext(ctx, "backup",
-		slog.String("status", "→"),
-		slog.String("src", filename),
-		slog.String("dst", backupPath))
-
-	// Move file to dotfiles root
-	newPath := app.profile.DotfilesDir() + "/" + path.Base(filename)
-	if err = os.Rename(filename, newPath); err != nil {
-		return err
+	if err != nil {
+		return false, err
 	}
 
-	linker := NewLinker(osfs, app.logger)
-
-	// Add a symlink to the moved file
-	if err = linker.SetSymlink(newPath, filename); err != nil {
-		return err
+	if fi.Mode()&os.ModeSymlink != os.ModeSymlink {
+		return false, nil
 	}
 
-	// TODO: write to config file
+	target, err := osfs.Readlink(dest)
+	if err != nil {
+		return false, err
+	}
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(dest), target)
+	}
 
-	return nil
+	return IsInside(app.profile.DotfilesDir(), target), nil
 }
 
-func (app *App) cleanAction(ctx context.Context) error {
-	cleaner := NewCleaner(osfs, app.logger)
-	if err := cleaner.CleanDeadSymlinks(ctx, app.profile.DestinationDir()); err != nil {
-		return fmt.Errorf("Error cleaning dead symlinks: %s", err)
+// findBackup returns the path of the latest existing backup of the original file.
+func (app *App) findBackup(original string) (string, bool) {
+	if backup, ok := app.manifest.LatestBackup(original); ok {
+		if _, err := osfs.Lstat(backup.Backup); err == nil {
+			return backup.Backup, true
+		}
 	}
 
-	return nil
-}
-
-func (app *App) installAction(ctx context.Context) error {
-	// Default action: install
-	cleaner := NewCleaner(osfs, app.logger)
-	err := cleaner.CleanDeadSymlinks(ctx, app.profile.DestinationDir())
+	generations, err := LoadBackupGenerations(app.profile.BackupDir())
 	if err != nil {
-		return fmt.Errorf("Error cleaning dead symlinks: %s", err)
+		app.logger.Warn("Cannot read backups", slog.Any("error", err))
 	}
-
-	srcDirAbs := app.profile.DotfilesDir()
-	if app.profile.SourcesDir() != "" {
-		if _, err = os.Stat(app.profile.SourcesDir()); os.IsNotExist(err) {
-			return fmt.Errorf("Sources directory `%s' does not exist.", app.profile.SourcesDir())
+	for i := len(generations) - 1; i >= 0; i-- {
+		if backup, ok := generations[i].Find(original); ok {
+			if _, err = osfs.Lstat(backup); err == nil {
+				return backup, true
+			}
 		}
-		if err != nil {
-			return fmt.Errorf("Error reading sources directory `%s': %s", app.profile.SourcesDir(), err)
+	}
+
+	// Backups made before backup generations existed.
+	rel, err := filepath.Rel(app.profile.DestinationDir(), original)
+	if err != nil || !IsInside(app.profile.DestinationDir(), original) {
+		return "", false
+	}
+	backup := path.Join(app.profile.BackupDir(), rel)
+	if _, err = osfs.Lstat(backup); err != nil {
+		return "", false
+	}
+	return backup, true
+}
+
+// selectProfilePaths returns the profile paths chosen by selector.
+func selectProfilePaths(profilePaths []string, selector string) ([]string, error) {
+	var selected []string
+	for _, p := range profilePaths {
+		if MatchProfilePath(p, selector) {
+			selected = append(selected, p)
 		}
-		srcDirAbs += "/" + app.profile.SourcesDir()
 	}
 
-	mapping := app.getMapping(ctx, srcDirAbs)
-	linker := NewLinker(osfs, app.logger)
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No profile matches %s", selector)
+	}
+	return selected, nil
+}
 
-	app.logger.InfoContext(ctx, "--> Installing dotfiles...", slog.String("profile", app.profile.Filepath()))
+// addProfilePaths returns the path of the profile to add the file to: the one
+// whose destination directory or root is the closest one containing the file.
+func addProfilePaths(profilePaths []string, filename string) ([]string, error) {
+	if len(profilePaths) < 2 {
+		return profilePaths, nil
+	}
 
-	// filter mapping:
-	// - non-existent files
-	// - already installed files
-	filterMapping(mapping, func(src, dst string) bool {
-		srcAbs := path.Join(srcDirAbs, src)
-		destAbs := path.Join(app.profile.DestinationDir(), dst)
-		if _, err := osfs.Stat(srcAbs); err != nil {
-			if osfs.IsNotExist(err) {
-				app.logger.WarnContext(ctx, "Source file does not exist", slog.String("path", srcAbs))
-				return false
-			}
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
 
-			app.logger.ErrorContext(ctx, "Error processing source file", slog.String("path", src), slog.Any("error", err))
-			app.exit(1)
-		}
-		needSymlink, err := linker.NeedSymlink(ctx, srcAbs, destAbs)
+	var candidates []string
+	var destDir string
+	for _, profilePath := range profilePaths {
+		profile, err := NewProfile(profilePath)
 		if err != nil {
-			app.logger.ErrorContext(ctx, "Error processing destination file", slog.String("path", destAbs), slog.Any("error", err))
-			app.exit(1)
+			return nil, fmt.Errorf("Cannot read profile %s: %w", profilePath, err)
 		}
-		return needSymlink
-	})
 
-	if len(mapping) == 0 {
-		return nil
+		dir := mappingDir(profile, filename)
+		if dir == "" || len(dir) < len(destDir) {
+			continue
+		}
+		if len(dir) > len(destDir) {
+			candidates = nil
+			destDir = dir
+		}
+		candidates = append(candidates, profilePath)
 	}
 
-	app.logger.InfoContext(ctx, "Installing dotfiles",
-		slog.String("src", srcDirAbs),
-		slog.String("dst", app.profile.DestinationDir()))
-	for src, dst := range mapping {
-		app.installDotfile(ctx, src, dst, linker, srcDirAbs)
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("File %s is outside of destination directories and roots of all profiles, choose a profile with '--profile'", filename)
+	case 1:
+		return candidates, nil
+	default:
+		return nil, fmt.Errorf("Several profiles can have the file %s, choose one with '--profile': %s",
+			filename, strings.Join(candidates, ", "))
 	}
+}
 
-	return nil
+// mappingDir returns the deepest of the destination directory and the roots
+// of the profile that contains the file, or "" if none does.
+func mappingDir(profile *Profile, filename string) string {
+	var dir string
+	if IsInside(profile.DestinationDir(), filename) {
+		dir = profile.DestinationDir()
+	}
+	for _, root := range profile.Data().Roots {
+		if IsInside(root, filename) && len(root) > len(dir) {
+			dir = root
+		}
+	}
+	return dir
 }
 
-func (app *App) getProfilePaths(ctx context.Context, profileArg any) []string {
+func (app *App) getProfilePaths(ctx context.Context, profileArg any, readOnly bool) []string {
 	var profilePath string
 	if profileArg != nil {
 		profilePath = profileArg.(string)
@@ -274,6 +1286,15 @@ func (app *App) getProfilePaths(ctx context.Context, profileArg any) []string {
 		app.exit(1)
 	}
 
+	if readOnly {
+		return []string{profilePath}
+	}
+	// A broken profile is not remembered, so plain 'dotbro' keeps using the
+	// profiles that work.
+	if _, err = NewProfile(profilePath); err != nil {
+		return []string{profilePath}
+	}
+
 	cfg.AddProfile(profilePath)
 
 	if err = cfg.Save(ctx); err != nil {
@@ -283,8 +1304,8 @@ func (app *App) getProfilePaths(ctx context.Context, profileArg any) []string {
 	return []string{profilePath}
 }
 
-func (app *App) getMapping(ctx context.Context, srcDirAbs string) map[string]string {
-	mapping := make(map[string]string)
+func (app *App) getMapping(ctx context.Context, srcDirAbs string) map[string]MappingEntry {
+	mapping := make(map[string]MappingEntry)
 
 	if len(app.profile.Data().Mapping) == 0 {
 		// install all the things
@@ -308,7 +1329,7 @@ func (app *App) getMapping(ctx context.Context, srcDirAbs string) map[string]str
 		}
 
 		for _, fileInfo := range files {
-			mapping[fileInfo.Name()] = fileInfo.Name()
+			mapping[fileInfo.Name()] = MappingEntry{Destination: fileInfo.Name()}
 		}
 
 		// filter excludes
@@ -321,54 +1342,471 @@ func (app *App) getMapping(ctx context.Context, srcDirAbs string) map[string]str
 			app.logger.WarnContext(ctx, "Excludes in config make no sense when mapping is specified, omitting them.")
 		}
 
-		mapping = app.profile.Data().Mapping
+		var patterns []string
+		for src, entry := range app.profile.Data().Mapping {
+			if !entry.Matches(app.machine) {
+				app.logger.DebugContext(ctx, "Mapping entry does not match this machine, skipping",
+					slog.String("src", src),
+					slog.String("dst", entry.Destination))
+				continue
+			}
+			if IsGlob(src) {
+				patterns = append(patterns, src)
+				continue
+			}
+			mapping[src] = entry
+		}
+
+		// Explicit entries win over patterns, and patterns are expanded
+		// in sorted order, so the first pattern matching a file wins.
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			app.expandPattern(ctx, mapping, pattern, app.profile.Data().Mapping[pattern], srcDirAbs)
+		}
 	}
 
 	return mapping
 }
 
-func (app *App) installDotfile(ctx context.Context, src, dest string, linker Linker, srcDirAbs string) {
+// expandPattern adds an entry for every file matching the glob pattern to the
+// mapping. The destination of the pattern entry is a directory: each file
+// keeps its path relative to the static part of the pattern in there.
+func (app *App) expandPattern(ctx context.Context, mapping map[string]MappingEntry, pattern string, entry MappingEntry, srcDirAbs string) {
+	files, err := ExpandGlob(srcDirAbs, pattern)
+	if err != nil {
+		app.logger.ErrorContext(ctx, "Error expanding mapping pattern", slog.String("pattern", pattern), slog.Any("error", err))
+		app.exit(1)
+	}
+
+	if len(files) == 0 {
+		app.logger.WarnContext(ctx, "Mapping pattern matches no files", slog.String("pattern", pattern))
+		return
+	}
+
+	base := GlobBase(pattern)
+	for _, src := range files {
+		if _, ok := mapping[src]; ok {
+			continue
+		}
+
+		rel := strings.TrimPrefix(strings.TrimPrefix(src, base), "/")
+		fileEntry := entry
+		fileEntry.Destination = path.Join(entry.Destination, rel)
+		mapping[src] = fileEntry
+	}
+}
+
+// planDotfile adds steps needed to install a single dotfile to the plan.
+func (app *App) planDotfile(ctx context.Context, plan *Plan, linker Linker, src string, entry MappingEntry, srcDirAbs string) error {
 	srcAbs := path.Join(srcDirAbs, src)
-	destAbs := path.Join(app.profile.DestinationDir(), dest)
+	destAbs := app.profile.DestinationPath(entry.Destination)
+
+	if _, err := osfs.Stat(srcAbs); err != nil {
+		if osfs.IsNotExist(err) {
+			return app.planMissingSource(ctx, plan, srcAbs, destAbs, entry)
+		}
+		return fmt.Errorf("Error processing source file %s: %s", src, err)
+	}
+
+	if entry.HasContent() {
+		return app.planCopy(ctx, plan, linker, srcAbs, destAbs, entry.InstallMode(), app.conflictPolicy(entry))
+	}
+
+	relative := entry.RelativeLinks(app.profile.RelativeLinks())
+	return app.planLink(ctx, plan, linker, srcAbs, destAbs, relative, app.conflictPolicy(entry))
+}
+
+// planMissingSource adds steps needed to install a dotfile whose source does
+// not exist to the plan. With the adopt conflict policy, a real file or
+// directory at the destination is moved into the dotfiles directory in place
+// of the source and installed back from there. Otherwise the dotfile is skipped.
+func (app *App) planMissingSource(ctx context.Context, plan *Plan, srcAbs, destAbs string, entry MappingEntry) error {
+	fi, err := osfs.Lstat(destAbs)
+	if err != nil && !osfs.IsNotExist(err) {
+		return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+	}
+	adoptable := err == nil && fi.Mode()&os.ModeSymlink != os.ModeSymlink && !plan.Removes(destAbs) &&
+		entry.InstallMode() != ModeTemplate
+
+	if !adoptable || app.conflictPolicy(entry) != ConflictAdopt {
+		app.logger.WarnContext(ctx, "Source file does not exist", slog.String("path", srcAbs))
+		if adoptable {
+			app.logger.InfoContext(ctx, "Run 'dotbro adopt' to move the existing destination into your dotfiles.",
+				slog.String("tip", "TIP"),
+				slog.String("path", destAbs))
+		}
+		return nil
+	}
 
-	needBackup, err := linker.NeedBackup(destAbs)
+	plan.Add(StepAdopt, destAbs, srcAbs)
+
+	if !entry.HasContent() {
+		plan.AddSymlink(srcAbs, destAbs, entry.RelativeLinks(app.profile.RelativeLinks()))
+		return nil
+	}
+
+	// Copies are made back from the adopted files.
+	files, err := CopyPairs(destAbs, srcAbs)
 	if err != nil {
-		app.logger.ErrorContext(ctx, "Error processing destination file", slog.String("path", destAbs), slog.Any("error", err))
-		app.exit(1)
+		return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+	}
+	for _, file := range files {
+		sum, err := Checksum(osfs, file.Src)
+		if err != nil {
+			return fmt.Errorf("Error processing destination file %s: %s", file.Src, err)
+		}
+		plan.AddCopy(file.Dst, file.Src, sum)
 	}
+	return nil
+}
 
-	if needBackup {
-		oldpath := destAbs
-		newpath := app.profile.BackupDir() + "/" + dest
-		err = linker.Move(ctx, oldpath, newpath)
+// planLink adds steps needed to symlink the source to the plan.
+//
+// If the source is a directory and the destination is a real directory,
+// the directory is not replaced: its content is linked one by one instead
+// (unfolded). When the destination directory holds nothing but symlinks to
+// the source, it is folded back into a single directory symlink.
+func (app *App) planLink(ctx context.Context, plan *Plan, linker Linker, srcAbs, destAbs string, relative bool, policy string) error {
+	state := DestMissing
+	if !plan.Removes(destAbs) {
+		var err error
+		state, err = linker.State(ctx, srcAbs, destAbs)
 		if err != nil {
-			app.logger.ErrorContext(ctx, "Error on file backup", slog.String("path", oldpath), slog.Any("error", err))
-			app.exit(1)
+			return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+		}
+	}
+
+	if state == DestBlocked {
+		unfolded, err := linker.Unfolded(srcAbs, destAbs)
+		if err != nil {
+			return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+		}
+
+		if unfolded {
+			foldable, err := app.foldable(ctx, plan, linker, srcAbs, destAbs)
+			if err != nil {
+				return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+			}
+			if !foldable {
+				return app.planUnfold(ctx, plan, linker, srcAbs, destAbs, relative, policy)
+			}
+			if err = app.planFold(plan, destAbs); err != nil {
+				return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+			}
+			state = DestMissing
+		}
+	}
+
+	switch state {
+	case DestLinked:
+		return nil
+	case DestWrongLink, DestBlocked:
+		applied, err := app.planConflict(ctx, plan, srcAbs, destAbs, state, policy, true)
+		if err != nil || applied == ConflictSkip {
+			return err
 		}
 	}
 
-	err = linker.SetSymlink(srcAbs, destAbs)
+	plan.AddSymlink(srcAbs, destAbs, relative)
+	return nil
+}
+
+// planUnfold adds steps needed to link every entry of the source directory
+// into the real destination directory to the plan.
+func (app *App) planUnfold(ctx context.Context, plan *Plan, linker Linker, srcAbs, destAbs string, relative bool, policy string) error {
+	entries, err := os.ReadDir(srcAbs)
 	if err != nil {
-		app.logger.ErrorContext(ctx, "Error creating symlink", slog.String("src", srcAbs), slog.String("dst", destAbs), slog.Any("error", err))
-		app.exit(1)
+		return fmt.Errorf("Error processing source file %s: %s", srcAbs, err)
 	}
 
-	app.logger.InfoContext(ctx, "set symlink",
-		slog.String("status", "+"),
-		slog.String("src", src),
-		slog.String("dst", dest))
+	app.logger.DebugContext(ctx, "Destination is a directory, linking its content",
+		slog.String("src", srcAbs),
+		slog.String("dst", destAbs))
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if err = app.planLink(ctx, plan, linker, path.Join(srcAbs, name), path.Join(destAbs, name), relative, policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// exit actually calls os.Exit after logger logs exit message.
-func (app *App) exit(exitCode int) {
-	app.logger.Debug("Exit", slog.Int("code", exitCode))
-	os.Exit(exitCode)
+// foldable reports whether the destination directory contains nothing but
+// symlinks to the respective entries of the source directory, possibly in
+// nested directories of the same kind. Entries the plan removes are ignored.
+func (app *App) foldable(ctx context.Context, plan *Plan, linker Linker, srcAbs, destAbs string) (bool, error) {
+	entries, err := os.ReadDir(destAbs)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		src := path.Join(srcAbs, entry.Name())
+		dest := path.Join(destAbs, entry.Name())
+		if plan.Removes(dest) {
+			continue
+		}
+
+		if entry.Type()&os.ModeSymlink == os.ModeSymlink {
+			state, err := linker.State(ctx, src, dest)
+			if err != nil || state != DestLinked {
+				return false, err
+			}
+			continue
+		}
+
+		if !entry.IsDir() {
+			return false, nil
+		}
+		if fi, err := osfs.Stat(src); err != nil || !fi.IsDir() {
+			return false, nil
+		}
+		if ok, err := app.foldable(ctx, plan, linker, src, dest); err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// planFold adds steps needed to empty and remove the foldable destination
+// directory to the plan.
+func (app *App) planFold(plan *Plan, destAbs string) error {
+	entries, err := os.ReadDir(destAbs)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		dest := path.Join(destAbs, entry.Name())
+		switch {
+		case plan.Removes(dest):
+		case entry.IsDir():
+			if err = app.planFold(plan, dest); err != nil {
+				return err
+			}
+		default:
+			plan.Add(StepUnlink, "", dest)
+		}
+	}
+
+	plan.Add(StepRemoveDir, "", destAbs)
+	return nil
+}
+
+// planCopy adds steps needed to install a copy of the source to the plan.
+// In template mode, the rendered source is installed instead.
+// A directory is copied file by file.
+func (app *App) planCopy(ctx context.Context, plan *Plan, linker Linker, srcAbs, destAbs, mode, policy string) error {
+	files, err := CopyPairs(srcAbs, destAbs)
+	if err != nil {
+		return fmt.Errorf("Error processing source file %s: %s", srcAbs, err)
+	}
+
+	for _, file := range files {
+		// Nothing under a directory destination must be touched before
+		// a symlink or a file in place of the directory itself is moved away.
+		if file.Dst != destAbs && !plan.Removes(destAbs) {
+			state := DestMissing
+			fi, err := osfs.Lstat(destAbs)
+			switch {
+			case err == nil && fi.Mode()&os.ModeSymlink == os.ModeSymlink:
+				state = DestWrongLink
+			case err == nil && !fi.IsDir():
+				state = DestBlocked
+			case err != nil && !osfs.IsNotExist(err):
+				return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+			}
+
+			if state != DestMissing {
+				applied, err := app.planConflict(ctx, plan, srcAbs, destAbs, state, policy, false)
+				if err != nil || applied == ConflictSkip {
+					return err
+				}
+			}
+		}
+
+		if err = app.planCopyFile(ctx, plan, linker, file.Src, file.Dst, mode, policy, plan.Removes(destAbs)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// planCopyFile adds steps needed to install a copy of a single file to the plan.
+// If destGone is true, the destination is known not to exist by the time the
+// copy is made.
+func (app *App) planCopyFile(ctx context.Context, plan *Plan, linker Linker, srcAbs, destAbs, mode, policy string, destGone bool) error {
+	content, wantSum, err := app.sourceContent(srcAbs, mode)
+	if err != nil {
+		return fmt.Errorf("Error processing source file %s: %s", srcAbs, err)
+	}
+
+	state := DestMissing
+	if !destGone && !plan.Removes(destAbs) {
+		state, err = linker.ContentState(ctx, wantSum, destAbs, app.recordedChecksum(destAbs))
+		if err != nil {
+			return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+		}
+	}
+
+	switch state {
+	case DestUpToDate:
+		return nil
+	case DestWrongLink, DestModified, DestBlocked:
+		if state == DestModified {
+			app.logger.WarnContext(ctx, "Copy was modified since it was installed", slog.String("path", destAbs))
+		}
+
+		// A rendered template cannot be adopted in place of the template itself.
+		applied, err := app.planConflict(ctx, plan, srcAbs, destAbs, state, policy, mode != ModeTemplate)
+		if err != nil || applied == ConflictSkip {
+			return err
+		}
+
+		if applied == ConflictAdopt {
+			// The adopted file becomes the source of the copy.
+			if wantSum, err = Checksum(osfs, destAbs); err != nil {
+				return fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+			}
+		}
+	}
+
+	if mode == ModeTemplate {
+		plan.AddRender(srcAbs, destAbs, content, wantSum)
+	} else {
+		plan.AddCopy(srcAbs, destAbs, wantSum)
+	}
+	return nil
+}
+
+// conflictPolicy returns the conflict policy for the mapping entry.
+// The --conflict option wins over the entry option, which wins over
+// the profile option.
+func (app *App) conflictPolicy(entry MappingEntry) string {
+	for _, policy := range []string{app.conflict, entry.Conflict, app.profile.Data().Install.Conflict} {
+		if policy != "" {
+			return policy
+		}
+	}
+	return ConflictBackup
+}
+
+// planConflict adds steps resolving a conflict with the existing destination
+// to the plan, according to the conflict policy, and returns the policy that
+// was applied. The destination must be left alone if it is ConflictSkip.
+//
+// Only regular files are adopted, and only if canAdopt is true. Otherwise
+// ConflictAdopt falls back to ConflictBackup.
+func (app *App) planConflict(ctx context.Context, plan *Plan, srcAbs, destAbs string, state DestState, policy string, canAdopt bool) (string, error) {
+	if policy == ConflictPrompt {
+		var err error
+		if policy, err = app.promptConflict(destAbs, state); err != nil {
+			return "", err
+		}
+	}
+
+	if policy == ConflictAdopt && !(canAdopt && isRegularFile(srcAbs) && isRegularFile(destAbs)) {
+		app.logger.DebugContext(ctx, "Cannot adopt destination, backing it up", slog.String("path", destAbs))
+		policy = ConflictBackup
+	}
+
+	switch policy {
+	case ConflictSkip:
+		app.logger.WarnContext(ctx, "Destination already exists, skipping",
+			slog.String("path", destAbs),
+			slog.String("state", state.String()))
+	case ConflictFail:
+		return "", fmt.Errorf("Destination %s already exists (%s)", destAbs, state)
+	case ConflictOverwrite:
+		plan.Add(StepDelete, "", destAbs)
+	case ConflictAdopt:
+		plan.Add(StepBackup, srcAbs, app.backups.PathFor(srcAbs, app.profile.DestinationDir()))
+		plan.Add(StepAdopt, destAbs, srcAbs)
+	default:
+		// Stale links into the dotfiles directory are not worth a backup,
+		// but links made by other tools are.
+		if state == DestWrongLink {
+			managed, err := app.isManagedSymlink(destAbs)
+			if err != nil {
+				return "", fmt.Errorf("Error processing destination file %s: %s", destAbs, err)
+			}
+			if managed {
+				plan.Add(StepRemoveWrongSymlink, "", destAbs)
+				break
+			}
+		}
+		plan.Add(StepBackup, destAbs, app.backups.PathFor(destAbs, app.profile.DestinationDir()))
+	}
+
+	return policy, nil
 }
 
-func filterMapping(mapping map[string]string, callback func(src, dst string) bool) {
-	for src, dst := range mapping {
-		if !callback(src, dst) {
-			delete(mapping, src)
+// promptConflict asks the user how to resolve a conflict with the existing destination.
+func (app *App) promptConflict(destAbs string, state DestState) (string, error) {
+	policies := []string{ConflictBackup, ConflictSkip, ConflictOverwrite, ConflictAdopt, ConflictFail}
+
+	for {
+		fmt.Fprintf(app.out, "%s already exists (%s). [b]ackup, [s]kip, [o]verwrite, [a]dopt or [f]ail? ", destAbs, state)
+		line, err := app.in.ReadString('\n')
+
+		answer := strings.ToLower(strings.TrimSpace(line))
+		for _, policy := range policies {
+			if answer != "" && (answer == policy || answer == policy[:1]) {
+				return policy, nil
+			}
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("No answer for %s: %s", destAbs, err)
 		}
 	}
 }
+
+// isRegularFile reports whether p is a regular file.
+func isRegularFile(p string) bool {
+	fi, err := osfs.Lstat(p)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// sourceContent returns the content installed from the source file and its
+// checksum. The content is only returned for templates: copies are made
+// directly from the source file.
+func (app *App) sourceContent(srcAbs, mode string) ([]byte, string, error) {
+	if mode != ModeTemplate {
+		sum, err := Checksum(osfs, srcAbs)
+		return nil, sum, err
+	}
+
+	content, err := RenderTemplate(srcAbs, NewTemplateData(app.machine, app.profile.Data().Variables))
+	if err != nil {
+		return nil, "", fmt.Errorf("render template: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+	return content, hex.EncodeToString(sum[:]), nil
+}
+
+// recordedChecksum returns the checksum of the copy or the rendered template
+// at dest made by dotbro last time.
+func (app *App) recordedChecksum(dest string) string {
+	if link, ok := app.manifest.Link(dest); ok {
+		return link.Checksum
+	}
+	return ""
+}
+
+// readOnly reports whether the command only reads the filesystem.
+func (app *App) readOnly(args map[string]any) bool {
+	return app.dryRun || args["check"] == true || args["status"] == true ||
+		args["restore"] == true && args["--list"] == true
+}
+
+// exit actually calls os.Exit after logger logs exit message.
+func (app *App) exit(exitCode int) {
+	app.logger.Debug("Exit", slog.Int("code", exitCode))
+	os.Exit(exitCode)
+}
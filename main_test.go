@@ -0,0 +1,995 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestApp returns an App for a profile with the content. The dotfiles,
+// destination and backup directories of the profile are in a temporary
+// directory, so the content must not have [directories] section.
+func newTestApp(t *testing.T, content string) *App {
+	t.Helper()
+
+	dir := t.TempDir()
+	directories := `[directories]
+dotfiles = "` + filepath.Join(dir, "dotfiles") + `"
+destination = "` + filepath.Join(dir, "home") + `"
+backup = "` + filepath.Join(dir, "backup") + `"
+`
+	for _, d := range []string{"dotfiles", "home", "backup"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0700))
+	}
+
+	profilePath := filepath.Join(dir, "dotbro.toml")
+	require.NoError(t, os.WriteFile(profilePath, []byte(directories+content), 0600))
+
+	profile, err := NewProfile(profilePath)
+	require.NoError(t, err)
+
+	logger := newDiscardLogger()
+	return &App{
+		logger:   logger,
+		in:       bufio.NewReader(strings.NewReader("")),
+		out:      new(bytes.Buffer),
+		profile:  profile,
+		manifest: NewManifest(logger, filepath.Join(dir, "manifest.json"), profilePath),
+		backups:  NewBackupGeneration(profile.BackupDir(), time.Now()),
+	}
+}
+
+// writeTestFile writes the file with the content, creating its directory.
+func writeTestFile(t *testing.T, filename, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0700))
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+}
+
+// assertSymlink asserts that the file is a symlink to the target.
+func assertSymlink(t *testing.T, target, filename string) {
+	t.Helper()
+	actual, err := os.Readlink(filename)
+	if assert.NoError(t, err) {
+		assert.Equal(t, target, actual)
+	}
+}
+
+// assertFileContent asserts that the file is a regular file with the content.
+func assertFileContent(t *testing.T, content, filename string) {
+	t.Helper()
+	fi, err := os.Lstat(filename)
+	require.NoError(t, err)
+	assert.True(t, fi.Mode().IsRegular(), "%s is not a regular file", filename)
+	actual, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(actual))
+}
+
+func TestApp_PlanUninstall(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+"zshrc" = ".zshrc"
+"gitconfig" = ".gitconfig"
+`)
+	dotfiles, home := app.profile.DotfilesDir(), app.profile.DestinationDir()
+	writeTestFile(t, filepath.Join(dotfiles, "vimrc"), "vimrc")
+	writeTestFile(t, filepath.Join(dotfiles, "zshrc"), "zshrc")
+	writeTestFile(t, filepath.Join(dotfiles, "gitconfig"), "gitconfig")
+	writeTestFile(t, filepath.Join(home, "elsewhere", "zshrc"), "foreign")
+
+	// .vimrc is managed and has a backup, .zshrc points outside of the
+	// dotfiles directory, and .gitconfig is a real file.
+	require.NoError(t, os.Symlink(filepath.Join(dotfiles, "vimrc"), filepath.Join(home, ".vimrc")))
+	require.NoError(t, os.Symlink(filepath.Join(home, "elsewhere", "zshrc"), filepath.Join(home, ".zshrc")))
+	writeTestFile(t, filepath.Join(home, ".gitconfig"), "local")
+	backup := filepath.Join(app.profile.BackupDir(), "old", ".vimrc")
+	writeTestFile(t, backup, "original")
+	app.manifest.AddBackup(filepath.Join(home, ".vimrc"), backup, time.Now())
+
+	plan, err := app.planUninstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{
+		{Kind: StepUnlink, Dst: filepath.Join(home, ".vimrc")},
+		{Kind: StepRestore, Src: backup, Dst: filepath.Join(home, ".vimrc")},
+	}, plan.Steps)
+
+	require.NoError(t, app.executePlan(t.Context(), plan, nil))
+	assertFileContent(t, "original", filepath.Join(home, ".vimrc"))
+	assert.NoFileExists(t, backup)
+	assertSymlink(t, filepath.Join(home, "elsewhere", "zshrc"), filepath.Join(home, ".zshrc"))
+	assertFileContent(t, "local", filepath.Join(home, ".gitconfig"))
+	_, ok := app.manifest.LatestBackup(filepath.Join(home, ".vimrc"))
+	assert.False(t, ok)
+}
+
+func TestApp_PlanUninstall_RelativeSymlink(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	dotfiles, home := app.profile.DotfilesDir(), app.profile.DestinationDir()
+	writeTestFile(t, filepath.Join(dotfiles, "vimrc"), "vimrc")
+	require.NoError(t, os.Symlink("../dotfiles/vimrc", filepath.Join(home, ".vimrc")))
+
+	plan, err := app.planUninstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{{Kind: StepUnlink, Dst: filepath.Join(home, ".vimrc")}}, plan.Steps)
+}
+
+// runDotbro runs dotbro with the arguments in a subprocess with HOME set to
+// the home directory, and returns its exit code and stdout.
+func runDotbro(t *testing.T, home string, argv ...string) (int, string) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMain_Subprocess$")
+	cmd.Env = append(os.Environ(), "HOME="+home, "DOTBRO_TEST_ARGS="+strings.Join(argv, "\n"))
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), stdout.String()
+	}
+	require.NoError(t, err)
+	return 0, stdout.String()
+}
+
+// TestMain_Subprocess runs main with the arguments passed by runDotbro.
+func TestMain_Subprocess(t *testing.T) {
+	argv := os.Getenv("DOTBRO_TEST_ARGS")
+	if argv == "" {
+		t.Skip("only run by runDotbro")
+	}
+
+	os.Args = append([]string{"dotbro"}, strings.Split(argv, "\n")...)
+	main()
+}
+
+func TestApp_StatusAction(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"linked" = ".linked"
+"missing" = ".missing"
+"wrong" = ".wrong"
+"blocked" = ".blocked"
+"gone" = ".gone"
+`)
+	dotfiles, home := app.profile.DotfilesDir(), app.profile.DestinationDir()
+	for _, name := range []string{"linked", "missing", "wrong", "blocked"} {
+		writeTestFile(t, filepath.Join(dotfiles, name), name)
+	}
+	require.NoError(t, os.Symlink(filepath.Join(dotfiles, "linked"), filepath.Join(home, ".linked")))
+	require.NoError(t, os.Symlink(filepath.Join(dotfiles, "linked"), filepath.Join(home, ".wrong")))
+	writeTestFile(t, filepath.Join(home, ".blocked"), "local")
+
+	inSync, err := app.statusAction(t.Context())
+	require.NoError(t, err)
+	assert.False(t, inSync)
+	assert.Equal(t, app.profile.Filepath()+`:
+  blocked         .blocked  → blocked
+  source missing  .gone     → gone
+  linked          .linked   → linked
+  missing         .missing  → missing
+  wrong target    .wrong    → wrong
+`, app.out.(*bytes.Buffer).String())
+}
+
+func TestApp_StatusAction_InSync(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	dotfiles, home := app.profile.DotfilesDir(), app.profile.DestinationDir()
+	writeTestFile(t, filepath.Join(dotfiles, "vimrc"), "vimrc")
+	require.NoError(t, os.Symlink(filepath.Join(dotfiles, "vimrc"), filepath.Join(home, ".vimrc")))
+
+	inSync, err := app.statusAction(t.Context())
+	require.NoError(t, err)
+	assert.True(t, inSync)
+}
+
+func TestMain_Status(t *testing.T) {
+	home := t.TempDir()
+	dotfiles := filepath.Join(home, "dotfiles")
+	backup := filepath.Join(home, "backup")
+	writeTestFile(t, filepath.Join(dotfiles, "vimrc"), "vimrc")
+	profilePath := filepath.Join(dotfiles, "dotbro.toml")
+	writeTestFile(t, profilePath, `[directories]
+backup = "`+backup+`"
+
+[mapping]
+"vimrc" = ".vimrc"
+`)
+
+	code, out := runDotbro(t, home, "status", "-c", profilePath)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, "missing  .vimrc  → vimrc")
+	assert.NoDirExists(t, backup, "status must not create the backup directory")
+	assert.NoFileExists(t, filepath.Join(home, ".dotbro", "config.json"), "status must not save the config")
+
+	require.NoError(t, os.Symlink(filepath.Join(dotfiles, "vimrc"), filepath.Join(home, ".vimrc")))
+	code, out = runDotbro(t, home, "status", "-c", profilePath)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "linked  .vimrc  → vimrc")
+}
+
+// addTestBackups saves a backup generation made at the time with backups of
+// the original files with the contents.
+func addTestBackups(t *testing.T, app *App, at time.Time, contents map[string]string) *BackupGeneration {
+	t.Helper()
+
+	g := NewBackupGeneration(app.profile.BackupDir(), at)
+	for original, content := range contents {
+		backup := g.PathFor(original, app.profile.DestinationDir())
+		writeTestFile(t, backup, content)
+		g.Add(original, backup)
+	}
+	require.NoError(t, g.Save())
+	return g
+}
+
+func TestMain_CheckInvalidProfile(t *testing.T) {
+	home := t.TempDir()
+	config := filepath.Join(home, ".dotbro", "config.json")
+	profilePath := filepath.Join(home, "dotfiles", "bad.toml")
+	writeTestFile(t, profilePath, "[mapping]\n\"vimrc\" = \".vimrc\"\nbashrc = [\n")
+
+	code, out := runDotbro(t, home, "check", "-c", profilePath)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out, profilePath+":3: ")
+	assert.NoFileExists(t, config, "check must not save the config")
+
+	code, _ = runDotbro(t, home, "-c", profilePath)
+	assert.Equal(t, 1, code)
+	assert.NoFileExists(t, config, "a broken profile must not be saved in the config")
+
+	writeTestFile(t, profilePath, "[mapping]\n\"vimrc\" = \".vimrc\"\n")
+	code, _ = runDotbro(t, home, "check", "-c", profilePath)
+	assert.Equal(t, 1, code, "the source is missing")
+	assert.NoFileExists(t, config, "check must not save the config")
+}
+
+func TestApp_RestoreAction_File(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, "")
+	dotfiles, home := app.profile.DotfilesDir(), app.profile.DestinationDir()
+	vimrc := filepath.Join(home, ".vimrc")
+	addTestBackups(t, app, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{vimrc: "old"})
+	addTestBackups(t, app, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), map[string]string{vimrc: "latest"})
+	writeTestFile(t, filepath.Join(dotfiles, "vimrc"), "vimrc")
+	require.NoError(t, os.Symlink(filepath.Join(dotfiles, "vimrc"), vimrc))
+
+	found, err := app.restoreAction(t.Context(), vimrc, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assertFileContent(t, "latest", vimrc)
+
+	found, err = app.restoreAction(t.Context(), filepath.Join(home, ".zshrc"), nil)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestApp_RestoreAction_Run(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, "")
+	home := app.profile.DestinationDir()
+	vimrc, zshrc := filepath.Join(home, ".vimrc"), filepath.Join(home, ".config", "zshrc")
+	g := addTestBackups(t, app, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{
+		vimrc: "vimrc",
+		zshrc: "zshrc",
+	})
+	addTestBackups(t, app, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), map[string]string{vimrc: "later"})
+
+	found, err := app.restoreAction(t.Context(), nil, g.ID)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assertFileContent(t, "vimrc", vimrc)
+	assertFileContent(t, "zshrc", zshrc)
+
+	found, err = app.restoreAction(t.Context(), nil, "20000101T000000Z")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestApp_RestoreAction_Occupied(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, "")
+	home := app.profile.DestinationDir()
+	vimrc, zshrc := filepath.Join(home, ".vimrc"), filepath.Join(home, ".zshrc")
+	addTestBackups(t, app, time.Now(), map[string]string{vimrc: "original", zshrc: "original"})
+	writeTestFile(t, vimrc, "local")
+	writeTestFile(t, filepath.Join(home, "elsewhere"), "foreign")
+	require.NoError(t, os.Symlink(filepath.Join(home, "elsewhere"), zshrc))
+
+	_, err := app.restoreAction(t.Context(), vimrc, nil)
+	assert.ErrorContains(t, err, "the path is occupied by a file not managed by dotbro")
+	assertFileContent(t, "local", vimrc)
+
+	_, err = app.restoreAction(t.Context(), zshrc, nil)
+	assert.ErrorContains(t, err, "the path is occupied by a file not managed by dotbro")
+	assertSymlink(t, filepath.Join(home, "elsewhere"), zshrc)
+}
+
+func TestApp_GetMapping_Patterns(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"zsh/*" = ".config/zsh/"
+"zsh/zshenv" = ".zshenv"
+"bin/**" = { destination = "bin", mode = "copy" }
+`)
+	dotfiles := app.profile.DotfilesDir()
+	for _, name := range []string{"zsh/zshrc", "zsh/zshenv", "bin/git-wip", "bin/git/git-up", "vimrc"} {
+		writeTestFile(t, filepath.Join(dotfiles, name), name)
+	}
+
+	mapping := app.getMapping(t.Context(), dotfiles)
+
+	assert.Equal(t, map[string]MappingEntry{
+		"zsh/zshrc":      {Destination: ".config/zsh/zshrc"},
+		"zsh/zshenv":     {Destination: ".zshenv"},
+		"bin/git-wip":    {Destination: "bin/git-wip", Mode: ModeCopy},
+		"bin/git/git-up": {Destination: "bin/git/git-up", Mode: ModeCopy},
+	}, mapping)
+}
+
+func TestApp_GetMapping_OverlappingPatterns(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"zsh/z*" = ".zsh"
+"zsh/*" = ".config/zsh"
+`)
+	dotfiles := app.profile.DotfilesDir()
+	writeTestFile(t, filepath.Join(dotfiles, "zsh", "zshrc"), "zshrc")
+	writeTestFile(t, filepath.Join(dotfiles, "zsh", "aliases"), "aliases")
+
+	mapping := app.getMapping(t.Context(), dotfiles)
+
+	// Patterns are expanded in sorted order, so "zsh/*" wins.
+	assert.Equal(t, map[string]MappingEntry{
+		"zsh/zshrc":   {Destination: ".config/zsh/zshrc"},
+		"zsh/aliases": {Destination: ".config/zsh/aliases"},
+	}, mapping)
+}
+
+func TestApp_PlanInstall_Unfold(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"nvim" = ".config/nvim"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "nvim")
+	dest := filepath.Join(app.profile.DestinationDir(), ".config", "nvim")
+	writeTestFile(t, filepath.Join(src, "init.lua"), "init")
+	writeTestFile(t, filepath.Join(src, "lua", "plugins.lua"), "plugins")
+	writeTestFile(t, filepath.Join(dest, "local.lua"), "local")
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{
+		{Kind: StepSymlink, Src: filepath.Join(src, "init.lua"), Dst: filepath.Join(dest, "init.lua")},
+		{Kind: StepSymlink, Src: filepath.Join(src, "lua"), Dst: filepath.Join(dest, "lua")},
+	}, plan.Steps)
+
+	require.NoError(t, app.executePlan(t.Context(), plan, nil))
+	assertSymlink(t, filepath.Join(src, "init.lua"), filepath.Join(dest, "init.lua"))
+	assertSymlink(t, filepath.Join(src, "lua"), filepath.Join(dest, "lua"))
+	assertFileContent(t, "local", filepath.Join(dest, "local.lua"))
+
+	// The foreign file keeps the directory unfolded.
+	plan, err = app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "unexpected steps: %v", plan.Steps)
+}
+
+func TestApp_PlanInstall_Fold(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"nvim" = ".config/nvim"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "nvim")
+	dest := filepath.Join(app.profile.DestinationDir(), ".config", "nvim")
+	writeTestFile(t, filepath.Join(src, "init.lua"), "init")
+	writeTestFile(t, filepath.Join(src, "lua", "plugins.lua"), "plugins")
+	require.NoError(t, os.MkdirAll(filepath.Join(dest, "lua"), 0700))
+	require.NoError(t, os.Symlink(filepath.Join(src, "init.lua"), filepath.Join(dest, "init.lua")))
+	require.NoError(t, os.Symlink(filepath.Join(src, "lua", "plugins.lua"), filepath.Join(dest, "lua", "plugins.lua")))
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{
+		{Kind: StepUnlink, Dst: filepath.Join(dest, "init.lua")},
+		{Kind: StepUnlink, Dst: filepath.Join(dest, "lua", "plugins.lua")},
+		{Kind: StepRemoveDir, Dst: filepath.Join(dest, "lua")},
+		{Kind: StepRemoveDir, Dst: dest},
+		{Kind: StepSymlink, Src: src, Dst: dest},
+	}, plan.Steps)
+
+	require.NoError(t, app.executePlan(t.Context(), plan, nil))
+	assertSymlink(t, src, dest)
+}
+
+func TestApp_PlanInstall_NoFoldWithForeignFiles(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"nvim" = ".config/nvim"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "nvim")
+	dest := filepath.Join(app.profile.DestinationDir(), ".config", "nvim")
+	writeTestFile(t, filepath.Join(src, "init.lua"), "init")
+	writeTestFile(t, filepath.Join(src, "lua", "plugins.lua"), "plugins")
+	require.NoError(t, os.MkdirAll(filepath.Join(dest, "lua"), 0700))
+	require.NoError(t, os.Symlink(filepath.Join(src, "init.lua"), filepath.Join(dest, "init.lua")))
+	require.NoError(t, os.Symlink(filepath.Join(src, "lua", "plugins.lua"), filepath.Join(dest, "lua", "plugins.lua")))
+	writeTestFile(t, filepath.Join(dest, "lua", "local.lua"), "local")
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "unexpected steps: %v", plan.Steps)
+
+	// A foreign file in place of a source entry is backed up, but the
+	// directory is not folded.
+	require.NoError(t, os.Remove(filepath.Join(dest, "init.lua")))
+	writeTestFile(t, filepath.Join(dest, "init.lua"), "local")
+
+	plan, err = app.planInstall(t.Context())
+	require.NoError(t, err)
+	require.Len(t, plan.Steps, 2)
+	assert.Equal(t, StepBackup, plan.Steps[0].Kind)
+	assert.Equal(t, filepath.Join(dest, "init.lua"), plan.Steps[0].Src)
+	assert.Equal(t, Step{Kind: StepSymlink, Src: filepath.Join(src, "init.lua"), Dst: filepath.Join(dest, "init.lua")}, plan.Steps[1])
+}
+
+func TestApp_AddAction(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	filename := filepath.Join(app.profile.DestinationDir(), ".bashrc")
+	writeTestFile(t, filename, "bashrc")
+
+	require.NoError(t, app.addAction(t.Context(), filename, ""))
+
+	src := filepath.Join(app.profile.DotfilesDir(), ".bashrc")
+	assertFileContent(t, "bashrc", src)
+	assertSymlink(t, src, filename)
+	backupPath, ok := app.backups.Find(filename)
+	require.True(t, ok)
+	assertFileContent(t, "bashrc", backupPath)
+	link, ok := app.manifest.Link(filename)
+	require.True(t, ok)
+	assert.Equal(t, src, link.Source)
+
+	profile, err := os.ReadFile(app.profile.Filepath())
+	require.NoError(t, err)
+	assert.Contains(t, string(profile), `".bashrc" = ".bashrc"`)
+}
+
+func TestApp_AddAction_RollbackOnProfileError(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	filename := filepath.Join(app.profile.DestinationDir(), ".bashrc")
+	writeTestFile(t, filename, "bashrc")
+	// The profile cannot be edited.
+	require.NoError(t, os.Remove(app.profile.Filepath()))
+
+	require.Error(t, app.addAction(t.Context(), filename, ""))
+
+	assertFileContent(t, "bashrc", filename)
+	assert.NoFileExists(t, filepath.Join(app.profile.DotfilesDir(), ".bashrc"))
+	assert.NoDirExists(t, app.backups.Dir())
+}
+
+func TestApp_ConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[install]
+conflict = "skip"
+`)
+	assert.Equal(t, ConflictSkip, app.conflictPolicy(MappingEntry{}))
+	assert.Equal(t, ConflictFail, app.conflictPolicy(MappingEntry{Conflict: ConflictFail}))
+
+	app.conflict = ConflictOverwrite
+	assert.Equal(t, ConflictOverwrite, app.conflictPolicy(MappingEntry{Conflict: ConflictFail}))
+
+	app = newTestApp(t, "")
+	assert.Equal(t, ConflictBackup, app.conflictPolicy(MappingEntry{}))
+}
+
+func TestApp_PlanInstall_Conflict(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		policy string
+		answer string
+		kinds  []StepKind
+		err    bool
+	}{
+		{policy: ConflictBackup, kinds: []StepKind{StepBackup, StepSymlink}},
+		{policy: ConflictSkip},
+		{policy: ConflictOverwrite, kinds: []StepKind{StepDelete, StepSymlink}},
+		{policy: ConflictFail, err: true},
+		{policy: ConflictAdopt, kinds: []StepKind{StepBackup, StepAdopt, StepSymlink}},
+		{policy: ConflictPrompt, answer: "x\no\n", kinds: []StepKind{StepDelete, StepSymlink}},
+		{policy: ConflictPrompt, answer: "skip\n"},
+		{policy: ConflictPrompt, err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.policy+"/"+strings.TrimSpace(tc.answer), func(t *testing.T) {
+			t.Parallel()
+
+			app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+			app.conflict = tc.policy
+			app.in = bufio.NewReader(strings.NewReader(tc.answer))
+			src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+			dest := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+			writeTestFile(t, src, "source")
+			writeTestFile(t, dest, "local")
+
+			plan, err := app.planInstall(t.Context())
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var kinds []StepKind
+			for _, step := range plan.Steps {
+				kinds = append(kinds, step.Kind)
+			}
+			assert.Equal(t, tc.kinds, kinds)
+		})
+	}
+}
+
+func TestApp_PlanInstall_StaleManagedSymlink(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+	dest := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, src, "source")
+	writeTestFile(t, filepath.Join(app.profile.DotfilesDir(), "old", "vimrc"), "old")
+	require.NoError(t, os.Symlink(filepath.Join(app.profile.DotfilesDir(), "old", "vimrc"), dest))
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{
+		{Kind: StepRemoveWrongSymlink, Dst: dest},
+		{Kind: StepSymlink, Src: src, Dst: dest},
+	}, plan.Steps)
+
+	// A symlink made by another tool is backed up.
+	other := filepath.Join(t.TempDir(), "vimrc")
+	writeTestFile(t, other, "other")
+	require.NoError(t, os.Remove(dest))
+	require.NoError(t, os.Symlink(other, dest))
+
+	plan, err = app.planInstall(t.Context())
+	require.NoError(t, err)
+	require.Len(t, plan.Steps, 2)
+	assert.Equal(t, StepBackup, plan.Steps[0].Kind)
+	assert.Equal(t, dest, plan.Steps[0].Src)
+}
+
+func TestApp_Install_DanglingForeignSymlink(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"nvim" = ".config/nvim"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "nvim")
+	dest := filepath.Join(app.profile.DestinationDir(), ".config", "nvim")
+	writeTestFile(t, filepath.Join(src, "init.lua"), "init")
+	require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0700))
+	require.NoError(t, os.Symlink("/nonexistent", dest))
+
+	installTestApp(t, app)
+
+	assertSymlink(t, src, dest)
+	backupPath, ok := app.backups.Find(dest)
+	require.True(t, ok)
+	assertSymlink(t, "/nonexistent", backupPath)
+}
+
+func TestApp_PlanInstall_AdoptMissingSource(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	app.conflict = ConflictAdopt
+	src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+	dest := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, dest, "local")
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{
+		{Kind: StepAdopt, Src: dest, Dst: src},
+		{Kind: StepSymlink, Src: src, Dst: dest},
+	}, plan.Steps)
+}
+
+func TestApp_PlanInstall_AdoptPresentSource(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+`)
+	app.conflict = ConflictAdopt
+	src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+	dest := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, src, "source")
+	writeTestFile(t, dest, "local")
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []Step{
+		{Kind: StepBackup, Src: src, Dst: app.backups.PathFor(src, app.profile.DestinationDir())},
+		{Kind: StepAdopt, Src: dest, Dst: src},
+		{Kind: StepSymlink, Src: src, Dst: dest},
+	}, plan.Steps)
+
+	require.NoError(t, app.executePlan(t.Context(), plan, nil))
+	assertFileContent(t, "local", src)
+	assertSymlink(t, src, dest)
+	backupPath, ok := app.backups.Find(src)
+	require.True(t, ok)
+	assertFileContent(t, "source", backupPath)
+}
+
+func TestApp_AdoptAction_SinglePath(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+"bashrc" = ".bashrc"
+`)
+	vimrc := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	bashrc := filepath.Join(app.profile.DestinationDir(), ".bashrc")
+	writeTestFile(t, vimrc, "vimrc")
+	writeTestFile(t, bashrc, "bashrc")
+
+	found, err := app.adoptAction(t.Context(), vimrc)
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+	assertFileContent(t, "vimrc", src)
+	assertSymlink(t, src, vimrc)
+	assertFileContent(t, "bashrc", bashrc)
+	assert.NoFileExists(t, filepath.Join(app.profile.DotfilesDir(), "bashrc"))
+
+	found, err = app.adoptAction(t.Context(), filepath.Join(app.profile.DestinationDir(), ".zshrc"))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestApp_PlanInstall_AdoptTemplate(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"gitconfig" = { destination = ".gitconfig", mode = "template" }
+`)
+	app.conflict = ConflictAdopt
+	src := filepath.Join(app.profile.DotfilesDir(), "gitconfig")
+	dest := filepath.Join(app.profile.DestinationDir(), ".gitconfig")
+	writeTestFile(t, dest, "local")
+
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	assert.True(t, plan.Empty(), "unexpected steps: %v", plan.Steps)
+
+	// The existing destination is backed up instead.
+	writeTestFile(t, src, "source")
+
+	plan, err = app.planInstall(t.Context())
+	require.NoError(t, err)
+	require.Len(t, plan.Steps, 2)
+	assert.Equal(t, StepBackup, plan.Steps[0].Kind)
+	assert.Equal(t, dest, plan.Steps[0].Src)
+	assert.Equal(t, StepRender, plan.Steps[1].Kind)
+}
+
+func TestApp_AddTarget(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, "")
+	home := app.profile.DestinationDir()
+
+	target, err := app.addTarget(filepath.Join(home, ".config", "nvim"), "")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(".config", "nvim"), target)
+
+	target, err = app.addTarget(filepath.Join(t.TempDir(), "vimrc"), "")
+	require.NoError(t, err)
+	assert.Equal(t, "vimrc", target)
+
+	target, err = app.addTarget(filepath.Join(home, ".vimrc"), "vim/./vimrc")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("vim", "vimrc"), target)
+
+	_, err = app.addTarget(filepath.Join(home, ".vimrc"), "/vim/vimrc")
+	assert.Error(t, err)
+}
+
+func TestApp_AddAction_Directory(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, "")
+	dir := filepath.Join(app.profile.DestinationDir(), ".config", "nvim")
+	writeTestFile(t, filepath.Join(dir, "init.lua"), "init")
+	writeTestFile(t, filepath.Join(dir, "lua", "plugins.lua"), "plugins")
+
+	require.NoError(t, app.addAction(t.Context(), dir, ""))
+
+	src := filepath.Join(app.profile.DotfilesDir(), ".config", "nvim")
+	assertSymlink(t, src, dir)
+	assertFileContent(t, "init", filepath.Join(src, "init.lua"))
+	assertFileContent(t, "plugins", filepath.Join(src, "lua", "plugins.lua"))
+
+	backupPath, ok := app.backups.Find(dir)
+	require.True(t, ok)
+	assertFileContent(t, "init", filepath.Join(backupPath, "init.lua"))
+	assertFileContent(t, "plugins", filepath.Join(backupPath, "lua", "plugins.lua"))
+}
+
+func TestApp_AddAction_As(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"bash/bashrc" = ".bashrc"
+`)
+	filename := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, filename, "vimrc")
+
+	require.NoError(t, app.addAction(t.Context(), filename, "vim/vimrc"))
+
+	src := filepath.Join(app.profile.DotfilesDir(), "vim", "vimrc")
+	assertFileContent(t, "vimrc", src)
+	assertSymlink(t, src, filename)
+
+	profile, err := os.ReadFile(app.profile.Filepath())
+	require.NoError(t, err)
+	assert.Contains(t, string(profile), `"vim/vimrc" = ".vimrc"`)
+}
+
+func TestApp_AddAction_Rejected(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, "")
+	filename := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, filename, "vimrc")
+	writeTestFile(t, filepath.Join(app.profile.DotfilesDir(), "vimrc"), "source")
+
+	testCases := map[string]struct {
+		filename string
+		as       string
+	}{
+		"outside of dotfiles directory": {filename: filename, as: "../vimrc"},
+		"dotfiles directory itself":     {filename: filename, as: "."},
+		"containing dotfiles directory": {filename: filepath.Dir(app.profile.DotfilesDir())},
+		"collision":                     {filename: filename, as: "vimrc"},
+		"symlink":                       {filename: filepath.Join(app.profile.DestinationDir(), ".link")},
+		"missing":                       {filename: filepath.Join(app.profile.DestinationDir(), ".bashrc")},
+	}
+	require.NoError(t, os.Symlink(filename, filepath.Join(app.profile.DestinationDir(), ".link")))
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, app.addAction(t.Context(), tc.filename, tc.as))
+		})
+	}
+
+	assertFileContent(t, "vimrc", filename)
+	assertFileContent(t, "source", filepath.Join(app.profile.DotfilesDir(), "vimrc"))
+	assert.NoDirExists(t, app.backups.Dir())
+}
+
+// writeTestProfile writes a profile with the destination directory and
+// returns its path.
+func writeTestProfile(t *testing.T, filename, destination string) string {
+	t.Helper()
+	writeTestFile(t, filename, `[directories]
+dotfiles = "`+filepath.Join(filepath.Dir(filename), "dotfiles")+`"
+destination = "`+destination+`"
+`)
+	return filename
+}
+
+func TestSelectProfilePaths(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	profiles := []string{
+		filepath.Join(dir, "home", "dotbro.toml"),
+		filepath.Join(dir, "work", "work.toml"),
+		filepath.Join(dir, "work", "dotbro.toml"),
+	}
+
+	selected, err := selectProfilePaths(profiles, "work")
+	require.NoError(t, err)
+	assert.Equal(t, profiles[1:2], selected)
+
+	selected, err = selectProfilePaths(profiles, "dotbro.toml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{profiles[0], profiles[2]}, selected)
+
+	selected, err = selectProfilePaths(profiles, profiles[2])
+	require.NoError(t, err)
+	assert.Equal(t, profiles[2:], selected)
+
+	_, err = selectProfilePaths(profiles, "personal")
+	assert.Error(t, err)
+}
+
+func TestAddProfilePaths(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home")
+	config := writeTestProfile(t, filepath.Join(dir, "config", "dotbro.toml"), filepath.Join(home, ".config"))
+	base := writeTestProfile(t, filepath.Join(dir, "base", "dotbro.toml"), home)
+	other := writeTestProfile(t, filepath.Join(dir, "other", "dotbro.toml"), home)
+	profiles := []string{base, config}
+
+	selected, err := addProfilePaths(profiles, filepath.Join(home, ".config", "nvim"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{config}, selected)
+
+	selected, err = addProfilePaths(profiles, filepath.Join(home, ".vimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{base}, selected)
+
+	// A single profile is used as is.
+	selected, err = addProfilePaths([]string{config}, filepath.Join(dir, "vimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{config}, selected)
+
+	_, err = addProfilePaths(profiles, filepath.Join(dir, "vimrc"))
+	assert.Error(t, err)
+
+	_, err = addProfilePaths([]string{base, other, config}, filepath.Join(home, ".vimrc"))
+	assert.ErrorContains(t, err, "Several profiles")
+
+	// A root is as good as the destination directory.
+	data := filepath.Join(dir, "data", "dotbro.toml")
+	writeTestFile(t, data, `[directories]
+dotfiles = "`+filepath.Join(dir, "data", "dotfiles")+`"
+destination = "`+filepath.Join(dir, "elsewhere")+`"
+
+[roots]
+data = "`+filepath.Join(home, ".local", "share")+`"
+`)
+	selected, err = addProfilePaths([]string{base, data}, filepath.Join(home, ".local", "share", "fonts"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{data}, selected)
+
+	// --profile resolves the ambiguity.
+	selected, err = selectProfilePaths([]string{base, other, config}, filepath.Join(dir, "other", "dotbro.toml"))
+	require.NoError(t, err)
+	selected, err = addProfilePaths(selected, filepath.Join(home, ".vimrc"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{other}, selected)
+}
+
+// installTestApp installs the profile of the app.
+func installTestApp(t *testing.T, app *App) {
+	t.Helper()
+	plan, err := app.planInstall(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, app.executePlan(t.Context(), plan, nil))
+}
+
+func TestApp_ForgetAction(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+# Vim
+"vimrc" = ".vimrc"
+
+"bashrc" = ".bashrc"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+	dest := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, src, "vimrc")
+	writeTestFile(t, filepath.Join(app.profile.DotfilesDir(), "bashrc"), "bashrc")
+	installTestApp(t, app)
+
+	found, err := app.forgetAction(t.Context(), dest, false)
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	assertFileContent(t, "vimrc", dest)
+	assertFileContent(t, "vimrc", src)
+	_, ok := app.manifest.Link(dest)
+	assert.False(t, ok)
+
+	profile, err := os.ReadFile(app.profile.Filepath())
+	require.NoError(t, err)
+	assert.NotContains(t, string(profile), "vimrc")
+	assert.NotContains(t, string(profile), "# Vim")
+	assert.Contains(t, string(profile), `"bashrc" = ".bashrc"`)
+
+	found, err = app.forgetAction(t.Context(), filepath.Join(app.profile.DestinationDir(), ".zshrc"), false)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestApp_ForgetAction_DeleteSource(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"nvim" = ".config/nvim"
+"bashrc" = ".bashrc"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "nvim")
+	dest := filepath.Join(app.profile.DestinationDir(), ".config", "nvim")
+	writeTestFile(t, filepath.Join(src, "init.lua"), "init")
+	writeTestFile(t, filepath.Join(app.profile.DotfilesDir(), "bashrc"), "bashrc")
+	installTestApp(t, app)
+
+	found, err := app.forgetAction(t.Context(), dest, true)
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	assertFileContent(t, "init", filepath.Join(dest, "init.lua"))
+	assert.NoDirExists(t, src)
+}
+
+func TestApp_ForgetAction_RollbackOnProfileError(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp(t, `[mapping]
+"vimrc" = ".vimrc"
+"bashrc" = ".bashrc"
+`)
+	src := filepath.Join(app.profile.DotfilesDir(), "vimrc")
+	dest := filepath.Join(app.profile.DestinationDir(), ".vimrc")
+	writeTestFile(t, src, "vimrc")
+	writeTestFile(t, filepath.Join(app.profile.DotfilesDir(), "bashrc"), "bashrc")
+	installTestApp(t, app)
+	// The profile cannot be edited.
+	require.NoError(t, os.Remove(app.profile.Filepath()))
+
+	found, err := app.forgetAction(t.Context(), dest, true)
+	assert.True(t, found)
+	require.Error(t, err)
+
+	assertSymlink(t, src, dest)
+	assertFileContent(t, "vimrc", src)
+	_, ok := app.manifest.Link(dest)
+	assert.True(t, ok)
+}
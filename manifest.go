@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultManifestDir is the directory where install manifests are stored.
+// It is the same directory as the one of the dotbro config file.
+const defaultManifestDir = "${HOME}/.dotbro"
+
+// Manifest keeps track of everything dotbro did for a single profile.
+type Manifest struct {
+	logger       *slog.Logger
+	manifestPath string
+	data         ManifestData
+}
+
+// ManifestData represents the JSON representation of the manifest file.
+type ManifestData struct {
+	// Profile is the path to the profile the manifest belongs to.
+	Profile string `json:"profile"`
+
+	// Links are symlinks and copies created by dotbro.
+	Links []ManifestLink `json:"links"`
+
+	// Backups are original files moved away by dotbro.
+	Backups []ManifestBackup `json:"backups"`
+}
+
+// ManifestLink represents a symlink, a copy or a rendered template created by dotbro.
+type ManifestLink struct {
+	// Source is the file the symlink points to or the copy is made of.
+	Source string `json:"source"`
+
+	// Destination is the path of the symlink or the copy itself.
+	Destination string `json:"destination"`
+
+	// Mode is the install mode. It is empty for symlinks.
+	Mode string `json:"mode,omitempty"`
+
+	// Checksum is the checksum of the copy or the rendered template content.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ManifestBackup represents a backup of an original file.
+type ManifestBackup struct {
+	// Original is the path where the file was located.
+	Original string `json:"original"`
+
+	// Backup is the path where the file was moved to.
+	Backup string `json:"backup"`
+
+	// Time is the moment when the backup was made.
+	Time time.Time `json:"time"`
+}
+
+// NewManifest returns a new Manifest for the profile.
+func NewManifest(logger *slog.Logger, manifestPath, profilePath string) *Manifest {
+	return &Manifest{
+		logger:       logger,
+		manifestPath: os.ExpandEnv(manifestPath),
+		data: ManifestData{
+			Profile: profilePath,
+		},
+	}
+}
+
+// ManifestFilepath returns the manifest file path for the profile.
+// Every profile gets its own manifest named after a hash of the profile path.
+func ManifestFilepath(manifestDir, profilePath string) string {
+	sum := sha256.Sum256([]byte(profilePath))
+	name := "manifest-" + hex.EncodeToString(sum[:8]) + ".json"
+	return filepath.Join(os.ExpandEnv(manifestDir), name)
+}
+
+// Links returns all symlinks recorded in the manifest.
+func (m *Manifest) Links() []ManifestLink {
+	return m.data.Links
+}
+
+// Link returns the recorded symlink at destination path.
+func (m *Manifest) Link(dest string) (ManifestLink, bool) {
+	for _, link := range m.data.Links {
+		if link.Destination == dest {
+			return link, true
+		}
+	}
+	return ManifestLink{}, false
+}
+
+// AddLink records a symlink, replacing any record for the same destination.
+func (m *Manifest) AddLink(src, dest string) {
+	m.RemoveLink(dest)
+	m.data.Links = append(m.data.Links, ManifestLink{Source: src, Destination: dest})
+}
+
+// AddCopy records a copy, replacing any record for the same destination.
+func (m *Manifest) AddCopy(src, dest, checksum string) {
+	m.addContent(src, dest, ModeCopy, checksum)
+}
+
+// AddRender records a rendered template, replacing any record for the same destination.
+func (m *Manifest) AddRender(src, dest, checksum string) {
+	m.addContent(src, dest, ModeTemplate, checksum)
+}
+
+// RemoveLink forgets the symlink or the copy at destination path.
+func (m *Manifest) RemoveLink(dest string) {
+	links := m.data.Links[:0]
+	for _, link := range m.data.Links {
+		if link.Destination != dest {
+			links = append(links, link)
+		}
+	}
+	m.data.Links = links
+}
+
+// Backups returns all backups recorded in the manifest.
+func (m *Manifest) Backups() []ManifestBackup {
+	return m.data.Backups
+}
+
+// AddBackup records a backup of the original file.
+func (m *Manifest) AddBackup(original, backup string, t time.Time) {
+	m.data.Backups = append(m.data.Backups, ManifestBackup{
+		Original: original,
+		Backup:   backup,
+		Time:     t.UTC(),
+	})
+}
+
+// RemoveBackup forgets the backup stored at backup path.
+func (m *Manifest) RemoveBackup(backup string) {
+	backups := m.data.Backups[:0]
+	for _, b := range m.data.Backups {
+		if b.Backup != backup {
+			backups = append(backups, b)
+		}
+	}
+	m.data.Backups = backups
+}
+
+// LatestBackup returns the most recent recorded backup of the original file.
+func (m *Manifest) LatestBackup(original string) (ManifestBackup, bool) {
+	var latest ManifestBackup
+	var found bool
+	for _, b := range m.data.Backups {
+		if b.Original == original && (!found || !b.Time.Before(latest.Time)) {
+			latest = b
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// Record updates the manifest according to a performed plan step.
+func (m *Manifest) Record(step Step) {
+	switch step.Kind {
+	case StepRemoveDeadSymlink, StepRemoveWrongSymlink, StepUnlink, StepRemoveCopy, StepDelete:
+		m.RemoveLink(step.Dst)
+	case StepBackup, StepBackupCopy:
+		m.AddBackup(step.Src, step.Dst, time.Now())
+	case StepSymlink:
+		m.AddLink(step.Src, step.Dst)
+	case StepCopy:
+		// A copy without a checksum is not managed, e.g. the one left by forget.
+		if step.Checksum != "" {
+			m.AddCopy(step.Src, step.Dst, step.Checksum)
+		}
+	case StepRender:
+		m.AddRender(step.Src, step.Dst, step.Checksum)
+	case StepRestore:
+		m.RemoveBackup(step.Src)
+	}
+}
+
+// Load reads Manifest data from the manifest file.
+// A missing manifest file is not an error: the manifest just stays empty.
+func (m *Manifest) Load(ctx context.Context) error {
+	data, err := os.ReadFile(m.manifestPath)
+	if os.IsNotExist(err) {
+		m.logger.DebugContext(ctx, "No manifest file found, starting fresh", slog.String("path", m.manifestPath))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read manifest file: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &m.data); err != nil {
+		return fmt.Errorf("parse manifest file: %w", err)
+	}
+
+	m.logger.DebugContext(ctx, "Loaded manifest", slog.String("path", m.manifestPath))
+	return nil
+}
+
+// Save atomically writes Manifest data to the manifest file.
+func (m *Manifest) Save(ctx context.Context) error {
+	if err := osfs.MkdirAll(filepath.Dir(m.manifestPath), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m.data, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err = WriteFileAtomic(m.manifestPath, data, 0600); err != nil {
+		return err
+	}
+
+	m.logger.DebugContext(ctx, "Saved manifest", slog.String("path", m.manifestPath))
+	return nil
+}
+
+// addContent records a copy or a rendered template, replacing any record
+// for the same destination.
+func (m *Manifest) addContent(src, dest, mode, checksum string) {
+	m.RemoveLink(dest)
+	m.data.Links = append(m.data.Links, ManifestLink{
+		Source:      src,
+		Destination: dest,
+		Mode:        mode,
+		Checksum:    checksum,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestFilepath(t *testing.T) {
+	t.Parallel()
+
+	first := ManifestFilepath("/state", "/first/dotbro.toml")
+	second := ManifestFilepath("/state", "/second/dotbro.toml")
+
+	assert.Equal(t, "/state", filepath.Dir(first))
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, ManifestFilepath("/state", "/first/dotbro.toml"))
+}
+
+func TestManifest_AddLink_ReplacesDestination(t *testing.T) {
+	t.Parallel()
+
+	m := NewManifest(newDiscardLogger(), "", "/profile.toml")
+
+	m.AddLink("/dotfiles/old", "/home/.vimrc")
+	m.AddLink("/dotfiles/new", "/home/.vimrc")
+
+	require.Len(t, m.Links(), 1)
+	link, ok := m.Link("/home/.vimrc")
+	assert.True(t, ok)
+	assert.Equal(t, "/dotfiles/new", link.Source)
+}
+
+func TestManifest_Record(t *testing.T) {
+	t.Parallel()
+
+	m := NewManifest(newDiscardLogger(), "", "/profile.toml")
+
+	m.Record(Step{Kind: StepBackup, Src: "/home/.vimrc", Dst: "/backup/.vimrc"})
+	m.Record(Step{Kind: StepSymlink, Src: "/dotfiles/vimrc", Dst: "/home/.vimrc"})
+	m.Record(Step{Kind: StepSymlink, Src: "/dotfiles/zshrc", Dst: "/home/.zshrc"})
+	m.Record(Step{Kind: StepRemoveWrongSymlink, Dst: "/home/.zshrc"})
+	m.Record(Step{Kind: StepRender, Src: "/dotfiles/gitconfig.tmpl", Dst: "/home/.gitconfig", Checksum: "sum"})
+
+	require.Len(t, m.Links(), 2)
+	assert.Equal(t, ManifestLink{Source: "/dotfiles/vimrc", Destination: "/home/.vimrc"}, m.Links()[0])
+	assert.Equal(t, ManifestLink{
+		Source:      "/dotfiles/gitconfig.tmpl",
+		Destination: "/home/.gitconfig",
+		Mode:        ModeTemplate,
+		Checksum:    "sum",
+	}, m.Links()[1])
+
+	require.Len(t, m.Backups(), 1)
+	assert.Equal(t, "/home/.vimrc", m.Backups()[0].Original)
+	assert.Equal(t, "/backup/.vimrc", m.Backups()[0].Backup)
+	assert.False(t, m.Backups()[0].Time.IsZero())
+}
+
+func TestManifest_Load_NotExists(t *testing.T) {
+	t.Parallel()
+
+	m := NewManifest(newDiscardLogger(), "testdata/non_existent_manifest.json", "/profile.toml")
+
+	require.NoError(t, m.Load(t.Context()))
+	assert.Empty(t, m.Links())
+}
+
+func TestManifest_SaveLoad(t *testing.T) {
+	t.Parallel()
+
+	manifestPath := filepath.Join(t.TempDir(), "state", "manifest.json")
+	backupTime := time.Date(2016, 9, 23, 12, 0, 0, 0, time.UTC)
+
+	m := NewManifest(newDiscardLogger(), manifestPath, "/profile.toml")
+	m.AddLink("/dotfiles/vimrc", "/home/.vimrc")
+	m.AddBackup("/home/.vimrc", "/backup/.vimrc", backupTime)
+	require.NoError(t, m.Save(t.Context()))
+
+	loaded := NewManifest(newDiscardLogger(), manifestPath, "")
+	require.NoError(t, loaded.Load(t.Context()))
+
+	assert.Equal(t, m.data, loaded.data)
+	assert.Equal(t, "/profile.toml", loaded.data.Profile)
+
+	// No temporary files are left behind.
+	entries, err := filepath.Glob(filepath.Join(filepath.Dir(manifestPath), "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{manifestPath}, entries)
+}
+
+func TestManifest_LatestBackup(t *testing.T) {
+	t.Parallel()
+
+	m := NewManifest(newDiscardLogger(), "", "/profile.toml")
+	m.AddBackup("/home/.vimrc", "/backup/1/.vimrc", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	m.AddBackup("/home/.vimrc", "/backup/2/.vimrc", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	m.AddBackup("/home/.zshrc", "/backup/3/.zshrc", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	backup, ok := m.LatestBackup("/home/.vimrc")
+	require.True(t, ok)
+	assert.Equal(t, "/backup/2/.vimrc", backup.Backup)
+
+	m.Record(Step{Kind: StepRestore, Src: "/backup/2/.vimrc", Dst: "/home/.vimrc"})
+
+	backup, ok = m.LatestBackup("/home/.vimrc")
+	require.True(t, ok)
+	assert.Equal(t, "/backup/1/.vimrc", backup.Backup)
+
+	_, ok = m.LatestBackup("/home/.bashrc")
+	assert.False(t, ok)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// StepKind is a kind of filesystem change performed by a plan step.
+type StepKind int
+
+const (
+	// StepRemoveDeadSymlink removes a broken symlink at Dst.
+	StepRemoveDeadSymlink StepKind = iota
+	// StepRemoveWrongSymlink removes a symlink at Dst that points elsewhere.
+	StepRemoveWrongSymlink
+	// StepBackup moves the file at Src to the backup path Dst.
+	StepBackup
+	// StepSymlink creates a symlink at Dst pointing to Src.
+	StepSymlink
+	// StepUnlink removes a symlink at Dst managed by dotbro.
+	StepUnlink
+	// StepRestore moves the backup file at Src back to its original path Dst.
+	StepRestore
+	// StepCopy copies the file at Src to Dst, replacing the file at Dst.
+	StepCopy
+	// StepRemoveCopy removes a copy at Dst made by dotbro.
+	StepRemoveCopy
+	// StepRender writes the rendered content of template Src to Dst,
+	// replacing the file at Dst.
+	StepRender
+	// StepRemoveDir removes an empty directory at Dst.
+	StepRemoveDir
+	// StepDelete deletes the file or the directory at Dst without a backup.
+	StepDelete
+	// StepAdopt moves the file at Src into the dotfiles directory at Dst.
+	StepAdopt
+	// StepBackupCopy copies the file or the directory at Src to the backup path Dst.
+	StepBackupCopy
+)
+
+// String returns a human-readable description of the step kind.
+func (k StepKind) String() string {
+	switch k {
+	case StepRemoveDeadSymlink:
+		return "remove dead symlink"
+	case StepRemoveWrongSymlink:
+		return "delete wrong symlink"
+	case StepBackup:
+		return "backup"
+	case StepSymlink:
+		return "set symlink"
+	case StepUnlink:
+		return "remove symlink"
+	case StepRestore:
+		return "restore"
+	case StepCopy:
+		return "copy"
+	case StepRemoveCopy:
+		return "remove copy"
+	case StepRender:
+		return "render template"
+	case StepRemoveDir:
+		return "remove directory"
+	case StepDelete:
+		return "delete"
+	case StepAdopt:
+		return "adopt"
+	case StepBackupCopy:
+		return "backup copy"
+	default:
+		return fmt.Sprintf("unknown step %d", int(k))
+	}
+}
+
+// Step is a single filesystem change.
+type Step struct {
+	Kind StepKind
+	Src  string
+	Dst  string
+
+	// Checksum is the checksum of the content a StepCopy or a StepRender installs.
+	Checksum string
+
+	// Content is the rendered content a StepRender installs.
+	Content []byte
+
+	// Relative makes a StepSymlink use a target relative to the symlink directory.
+	Relative bool
+}
+
+// Plan is an ordered list of filesystem changes an action is going to make.
+// The same plan is used to preview an action and to carry it out.
+type Plan struct {
+	Steps []Step
+}
+
+// Add appends a step to the plan.
+func (p *Plan) Add(kind StepKind, src, dst string) {
+	p.Steps = append(p.Steps, Step{Kind: kind, Src: src, Dst: dst})
+}
+
+// AddSymlink appends a step that symlinks src to dst.
+func (p *Plan) AddSymlink(src, dst string, relative bool) {
+	p.Steps = append(p.Steps, Step{Kind: StepSymlink, Src: src, Dst: dst, Relative: relative})
+}
+
+// AddCopy appends a step that copies src with the given checksum to dst.
+func (p *Plan) AddCopy(src, dst, checksum string) {
+	p.Steps = append(p.Steps, Step{Kind: StepCopy, Src: src, Dst: dst, Checksum: checksum})
+}
+
+// AddRender appends a step that writes the rendered content of template src
+// with the given checksum to dst.
+func (p *Plan) AddRender(src, dst string, content []byte, checksum string) {
+	p.Steps = append(p.Steps, Step{Kind: StepRender, Src: src, Dst: dst, Content: content, Checksum: checksum})
+}
+
+// Empty reports whether the plan has nothing to do.
+func (p *Plan) Empty() bool {
+	return len(p.Steps) == 0
+}
+
+// Removes reports whether the plan removes the file at path.
+func (p *Plan) Removes(path string) bool {
+	for _, step := range p.Steps {
+		switch step.Kind {
+		case StepRemoveDeadSymlink, StepRemoveWrongSymlink, StepBackup, StepUnlink, StepRemoveCopy, StepRemoveDir,
+			StepDelete, StepAdopt:
+			if step.pathRemoved() == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Log logs every step of the plan without performing it.
+func (p *Plan) Log(ctx context.Context, logger *slog.Logger) {
+	for _, step := range p.Steps {
+		logger.InfoContext(ctx, step.Kind.String(), step.attrs("dry-run")...)
+	}
+}
+
+// Execute performs the plan steps in order as a single transaction.
+// It stops at the first failed step and undoes all performed steps in reverse
+// order, so a failed plan leaves the filesystem as it was. When all steps are
+// performed, finish is called, if it is not nil, and its failure undoes the
+// steps too. If everything succeeds, every step is passed to done, if it is
+// not nil.
+func (p *Plan) Execute(ctx context.Context, linker Linker, logger *slog.Logger, finish func() error, done func(Step)) error {
+	tx := NewTransaction(linker, logger)
+	rollback := func(err error) error {
+		logger.WarnContext(ctx, "Rolling back changes", slog.Any("error", err))
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			return errors.Join(err, fmt.Errorf("rollback: %w", rollbackErr))
+		}
+		return err
+	}
+
+	for _, step := range p.Steps {
+		if err := tx.Do(ctx, step); err != nil {
+			return rollback(fmt.Errorf("%s %s: %w", step.Kind, step.Dst, err))
+		}
+
+		switch step.Kind {
+		case StepBackup:
+			// Linker.Move logs on its own.
+		case StepBackupCopy:
+			logger.InfoContext(ctx, step.Kind.String(), step.attrs("→")...)
+		case StepSymlink, StepCopy, StepRender:
+			logger.InfoContext(ctx, step.Kind.String(), step.attrs("+")...)
+		case StepUnlink, StepRemoveCopy, StepRemoveDir, StepDelete:
+			logger.InfoContext(ctx, step.Kind.String(), step.attrs("-")...)
+		case StepRestore, StepAdopt:
+			logger.InfoContext(ctx, step.Kind.String(), step.attrs("←")...)
+		default:
+			logger.InfoContext(ctx, step.Kind.String(), step.attrs("✓")...)
+		}
+	}
+
+	if finish != nil {
+		if err := finish(); err != nil {
+			return rollback(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.WarnContext(ctx, "Cannot delete replaced files", slog.Any("error", err))
+	}
+
+	if done != nil {
+		for _, step := range p.Steps {
+			done(step)
+		}
+	}
+	return nil
+}
+
+func (s Step) execute(ctx context.Context, linker Linker) error {
+	switch s.Kind {
+	case StepRemoveDeadSymlink, StepRemoveWrongSymlink, StepUnlink, StepRemoveCopy, StepRemoveDir:
+		return linker.Remove(s.Dst)
+	case StepDelete:
+		return linker.RemoveAll(s.Dst)
+	case StepBackup, StepRestore, StepAdopt:
+		return linker.Move(ctx, s.Src, s.Dst)
+	case StepSymlink:
+		if s.Relative {
+			return linker.SetRelativeSymlink(s.Src, s.Dst)
+		}
+		return linker.SetSymlink(s.Src, s.Dst)
+	case StepCopy:
+		return linker.Copy(s.Src, s.Dst)
+	case StepBackupCopy:
+		return linker.CopyAll(s.Src, s.Dst)
+	case StepRender:
+		return linker.Render(s.Src, s.Dst, s.Content)
+	default:
+		return fmt.Errorf("unknown step kind %d", int(s.Kind))
+	}
+}
+
+// pathRemoved returns the path that no longer exists after the step.
+func (s Step) pathRemoved() string {
+	if s.Kind == StepBackup || s.Kind == StepAdopt {
+		return s.Src
+	}
+	return s.Dst
+}
+
+func (s Step) attrs(status string) []any {
+	attrs := []any{slog.String("status", status)}
+	if s.Src != "" {
+		attrs = append(attrs, slog.String("src", s.Src))
+	}
+	switch s.Kind {
+	case StepRemoveDeadSymlink, StepRemoveWrongSymlink, StepUnlink, StepRemoveCopy, StepRemoveDir, StepDelete:
+		return append(attrs, slog.String("path", s.Dst))
+	}
+	return append(attrs, slog.String("dst", s.Dst))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan_Removes(t *testing.T) {
+	t.Parallel()
+
+	var plan Plan
+	plan.Add(StepRemoveDeadSymlink, "", "/dest/dead")
+	plan.Add(StepBackup, "/dest/file", "/backup/file")
+	plan.Add(StepSymlink, "/src/file", "/dest/file")
+	plan.Add(StepRemoveDir, "", "/dest/dir")
+
+	assert.True(t, plan.Removes("/dest/dead"))
+	assert.True(t, plan.Removes("/dest/dir"))
+	assert.True(t, plan.Removes("/dest/file"))
+	assert.False(t, plan.Removes("/backup/file"))
+	assert.False(t, plan.Removes("/dest/other"))
+}
+
+func TestPlan_Execute(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dest := filepath.Join(tmpDir, "dest")
+	backup := filepath.Join(tmpDir, "backup", "dest")
+	wrong := filepath.Join(tmpDir, "wrong")
+	dead := filepath.Join(tmpDir, "dead")
+
+	require.NoError(t, os.WriteFile(src, []byte("source"), 0600))
+	require.NoError(t, os.WriteFile(dest, []byte("original"), 0600))
+	require.NoError(t, os.Symlink(dest, wrong))
+	require.NoError(t, os.Symlink(filepath.Join(tmpDir, "nowhere"), dead))
+
+	var plan Plan
+	plan.Add(StepRemoveDeadSymlink, "", dead)
+	plan.Add(StepRemoveWrongSymlink, "", wrong)
+	plan.Add(StepBackup, dest, backup)
+	plan.Add(StepSymlink, src, dest)
+
+	var done []Step
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, func(step Step) {
+		done = append(done, step)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, plan.Steps, done)
+
+	assert.NoFileExists(t, dead)
+	assert.NoFileExists(t, wrong)
+
+	content, err := os.ReadFile(backup)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+
+	target, err := os.Readlink(dest)
+	require.NoError(t, err)
+	assert.Equal(t, src, target)
+}
+
+func TestPlan_Execute_StopsOnError(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	dest := filepath.Join(tmpDir, "dest")
+
+	var plan Plan
+	plan.Add(StepRemoveWrongSymlink, "", filepath.Join(tmpDir, "missing"))
+	plan.Add(StepSymlink, filepath.Join(tmpDir, "src"), dest)
+
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, nil)
+
+	assert.Error(t, err)
+	assert.NoFileExists(t, dest)
+}
+
+func TestPlan_Execute_RollsBack(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dest := filepath.Join(tmpDir, "dest")
+	backup := filepath.Join(tmpDir, "backup", "dest")
+
+	require.NoError(t, os.WriteFile(src, []byte("source"), 0600))
+	require.NoError(t, os.WriteFile(dest, []byte("original"), 0600))
+
+	var plan Plan
+	plan.Add(StepBackup, dest, backup)
+	plan.AddSymlink(src, dest, false)
+	plan.Add(StepRemoveWrongSymlink, "", filepath.Join(tmpDir, "missing"))
+
+	var done []Step
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, func(step Step) {
+		done = append(done, step)
+	})
+
+	assert.Error(t, err)
+	assert.Empty(t, done)
+	assert.NoDirExists(t, filepath.Dir(backup))
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+}
+
+func TestPlan_Execute_Uninstall(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dest := filepath.Join(tmpDir, "dest")
+	backup := filepath.Join(tmpDir, "backup", "dest")
+
+	require.NoError(t, os.WriteFile(src, []byte("source"), 0600))
+	require.NoError(t, os.Symlink(src, dest))
+	require.NoError(t, os.MkdirAll(filepath.Dir(backup), 0700))
+	require.NoError(t, os.WriteFile(backup, []byte("original"), 0600))
+
+	var plan Plan
+	plan.Add(StepUnlink, "", dest)
+	plan.Add(StepRestore, backup, dest)
+
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, nil)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+	assert.NoFileExists(t, backup)
+}
+
+func TestPlan_Execute_Render(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "gitconfig.tmpl")
+	dest := filepath.Join(tmpDir, "home", ".gitconfig")
+
+	require.NoError(t, os.WriteFile(src, []byte("{{ .Vars.email }}"), 0640))
+
+	var plan Plan
+	plan.AddRender(src, dest, []byte("user@example.com"), "sum")
+
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, nil)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", string(content))
+
+	fi, err := os.Stat(dest)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
+
+func TestPlan_Execute_Fold(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "dotfiles", "nvim")
+	dest := filepath.Join(tmpDir, "home", "nvim")
+
+	require.NoError(t, os.MkdirAll(src, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "init.lua"), nil, 0600))
+	require.NoError(t, os.MkdirAll(dest, 0700))
+	require.NoError(t, os.Symlink(filepath.Join(src, "init.lua"), filepath.Join(dest, "init.lua")))
+
+	var plan Plan
+	plan.Add(StepUnlink, "", filepath.Join(dest, "init.lua"))
+	plan.Add(StepRemoveDir, "", dest)
+	plan.AddSymlink(src, dest, false)
+
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, nil)
+	require.NoError(t, err)
+
+	target, err := os.Readlink(dest)
+	require.NoError(t, err)
+	assert.Equal(t, src, target)
+}
+
+func TestPlan_Execute_Adopt(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "dotfiles", "zshrc")
+	dest := filepath.Join(tmpDir, "home", ".zshrc")
+	backup := filepath.Join(tmpDir, "backup", "zshrc")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0700))
+	require.NoError(t, os.WriteFile(src, []byte("source"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Dir(dest), 0700))
+	require.NoError(t, os.WriteFile(dest, []byte("existing"), 0600))
+
+	var plan Plan
+	plan.Add(StepBackup, src, backup)
+	plan.Add(StepAdopt, dest, src)
+	plan.AddSymlink(src, dest, false)
+
+	assert.True(t, plan.Removes(dest))
+
+	err := plan.Execute(t.Context(), NewLinker(osfs, newDiscardLogger()), newDiscardLogger(), nil, nil)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(content))
+
+	content, err = os.ReadFile(backup)
+	require.NoError(t, err)
+	assert.Equal(t, "source", string(content))
+}
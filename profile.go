@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 // Profile represents a loaded profile with its file path and data.
@@ -17,69 +25,497 @@ type Profile struct {
 
 	// data contains the parsed profile data.
 	data ProfileData
+
+	// files are the profile file and all files it includes, included first.
+	files []string
+
+	// origins maps mapping sources to where their entries come from.
+	origins map[string]origin
+}
+
+// origin is where a mapping entry comes from.
+type origin struct {
+	// file is the profile file that has the entry.
+	file string
+
+	// key is the entry key as it is written in the file, before expansion.
+	key string
 }
 
 // ProfileData represents the data structure of a profile file.
 type ProfileData struct {
+	// Include lists profile files to merge this profile with. Paths are
+	// relative to the directory of the profile file.
+	Include []string `toml:"include" json:"include" yaml:"include"`
+
 	// Directories contains paths configuration for dotfiles management.
-	Directories Directories `toml:"directories" json:"directories"`
+	Directories Directories `toml:"directories" json:"directories" yaml:"directories"`
 
 	// Mapping defines source-to-destination file mappings.
-	Mapping map[string]string `toml:"mapping" json:"mapping"`
+	Mapping map[string]MappingEntry `toml:"mapping" json:"mapping" yaml:"mapping"`
 
 	// Files contains file filtering options.
-	Files Files `toml:"files" json:"files"`
+	Files Files `toml:"files" json:"files" yaml:"files"`
+
+	// Install contains options of how dotfiles are installed.
+	Install Install `toml:"install" json:"install" yaml:"install"`
+
+	// Variables are user-defined values that can be referenced as ${name}
+	// in directories and mapping, and are available to templates as .Vars.
+	Variables map[string]string `toml:"variables" json:"variables" yaml:"variables"`
+
+	// Roots are named directories that mapping destinations can refer to
+	// as "name:path" to be installed outside of the destination directory.
+	Roots map[string]string `toml:"roots" json:"roots" yaml:"roots"`
 }
 
 // Directories represents [directories] section of a profile.
 type Directories struct {
 	// Dotfiles is the root directory containing dotfiles to manage.
-	Dotfiles string `toml:"dotfiles" json:"dotfiles"`
+	Dotfiles string `toml:"dotfiles" json:"dotfiles" yaml:"dotfiles"`
 
 	// Sources is a subdirectory within Dotfiles containing actual dotfiles.
 	//
 	// Deprecated: use Dotfiles directly.
-	Sources string `toml:"sources" json:"sources"`
+	Sources string `toml:"sources" json:"sources" yaml:"sources"`
 
 	// Destination is the target directory where symlinks will be created.
-	Destination string `toml:"destination" json:"destination"`
+	Destination string `toml:"destination" json:"destination" yaml:"destination"`
 
 	// Backup is the directory for storing original files before symlinking.
-	Backup string `toml:"backup" json:"backup"`
+	Backup string `toml:"backup" json:"backup" yaml:"backup"`
+}
+
+// Install modes of a mapping entry.
+const (
+	// ModeLink installs the source as a symlink.
+	ModeLink = "link"
+
+	// ModeCopy installs a real copy of the source.
+	ModeCopy = "copy"
+
+	// ModeTemplate installs the source rendered as a Go text/template.
+	ModeTemplate = "template"
+)
+
+// Conflict policies define what to do with an existing file at a destination.
+const (
+	// ConflictBackup moves the existing file to the backup directory.
+	// A symlink pointing inside the dotfiles directory is deleted instead.
+	ConflictBackup = "backup"
+
+	// ConflictSkip leaves the existing file alone and does not install the entry.
+	ConflictSkip = "skip"
+
+	// ConflictOverwrite deletes the existing file without a backup.
+	ConflictOverwrite = "overwrite"
+
+	// ConflictFail stops with an error before anything is changed.
+	ConflictFail = "fail"
+
+	// ConflictAdopt moves the existing file into the dotfiles directory in
+	// place of the source, and then installs it.
+	ConflictAdopt = "adopt"
+
+	// ConflictPrompt asks the user what to do.
+	ConflictPrompt = "prompt"
+)
+
+// CheckConflictPolicy returns an error if policy is not a known conflict policy.
+// An empty policy is allowed and means the default one.
+func CheckConflictPolicy(policy string) error {
+	switch policy {
+	case "", ConflictBackup, ConflictSkip, ConflictOverwrite, ConflictFail, ConflictAdopt, ConflictPrompt:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unknown conflict policy '%s': supported policies are %s, %s, %s, %s, %s and %s",
+			policy, ConflictBackup, ConflictSkip, ConflictOverwrite, ConflictFail, ConflictAdopt, ConflictPrompt,
+		)
+	}
+}
+
+// MappingEntry represents a single entry of [mapping] section.
+//
+// An entry is either a destination path:
+//
+//	"vim/vimrc" = ".vimrc"
+//
+// or a table with the destination path and options:
+//
+//	"git/config" = { destination = ".gitconfig", mode = "copy" }
+//
+// A table can also carry conditions. The entry is installed only on machines
+// that match all of them:
+//
+//	"git/config.work" = { destination = ".gitconfig", hostname = "work-*" }
+//
+// false removes the entry with the same source inherited from included profiles:
+//
+//	"vim/vimrc" = false
+type MappingEntry struct {
+	// Destination is the destination path relative to the destination directory.
+	Destination string
+
+	// Mode defines how the source is installed: "link" (default), "copy"
+	// or "template".
+	Mode string
+
+	// Relative overrides [install.relative_links] for this entry, if set.
+	Relative *bool
+
+	// Conflict overrides [install.conflict] for this entry, if set.
+	Conflict string
+
+	// OS is a glob pattern the operating system must match, e.g. "linux".
+	OS string
+
+	// Hostname is a glob pattern the hostname must match, e.g. "work-*".
+	Hostname string
+
+	// Arch is a glob pattern the architecture must match, e.g. "arm64".
+	Arch string
+
+	// Env is either a name of an environment variable that must be set to
+	// a non-empty value, e.g. "CI", or a name and a glob pattern its value
+	// must match, e.g. "XDG_SESSION_TYPE=wayland".
+	Env string
+
+	// Removed reports whether the entry removes the inherited entry.
+	Removed bool
+}
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (e *MappingEntry) UnmarshalTOML(value any) error {
+	return e.fromValue(value)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (e *MappingEntry) UnmarshalYAML(node *yaml.Node) error {
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	return e.fromValue(value)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *MappingEntry) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return e.fromValue(value)
+}
+
+// HasContent reports whether the entry installs a real file instead of a symlink.
+func (e MappingEntry) HasContent() bool {
+	mode := e.InstallMode()
+	return mode == ModeCopy || mode == ModeTemplate
+}
+
+// InstallMode returns the install mode of the entry.
+func (e MappingEntry) InstallMode() string {
+	if e.Mode == "" {
+		return ModeLink
+	}
+	return e.Mode
+}
+
+// RelativeLinks reports whether the entry symlink must use a relative target.
+// defaultValue is the profile-wide setting.
+func (e MappingEntry) RelativeLinks(defaultValue bool) bool {
+	if e.Relative == nil {
+		return defaultValue
+	}
+	return *e.Relative
+}
+
+// Matches reports whether the machine satisfies all conditions of the entry.
+// An entry without conditions matches any machine.
+func (e MappingEntry) Matches(m Machine) bool {
+	if !matchCondition(e.OS, m.OS) || !matchCondition(e.Hostname, m.Hostname) || !matchCondition(e.Arch, m.Arch) {
+		return false
+	}
+
+	if e.Env == "" {
+		return true
+	}
+	name, pattern, hasValue := strings.Cut(e.Env, "=")
+	value := os.Getenv(name)
+	if !hasValue {
+		return value != ""
+	}
+	return matchCondition(pattern, value)
+}
+
+// matchCondition reports whether value matches the glob pattern.
+// An empty pattern matches anything.
+func matchCondition(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// fromValue fills the entry from a decoded string or table value.
+func (e *MappingEntry) fromValue(value any) error {
+	switch v := value.(type) {
+	case string:
+		*e = MappingEntry{Destination: v}
+		return nil
+	case bool:
+		if v {
+			return errors.New("mapping entry must be a string, a table or false")
+		}
+		*e = MappingEntry{Removed: true}
+		return nil
+	case map[string]any:
+		*e = MappingEntry{}
+		for key, option := range v {
+			var ok bool
+			switch key {
+			case "destination":
+				e.Destination, ok = option.(string)
+			case "mode":
+				e.Mode, ok = option.(string)
+			case "relative":
+				var relative bool
+				relative, ok = option.(bool)
+				e.Relative = &relative
+			case "conflict":
+				e.Conflict, ok = option.(string)
+			case "os":
+				e.OS, ok = option.(string)
+			case "hostname":
+				e.Hostname, ok = option.(string)
+			case "arch":
+				e.Arch, ok = option.(string)
+			case "env":
+				e.Env, ok = option.(string)
+			default:
+				return fmt.Errorf("unknown mapping option '%s'", key)
+			}
+			if !ok {
+				return fmt.Errorf("mapping option '%s' has invalid type %T", key, option)
+			}
+		}
+		return e.validate()
+	default:
+		return fmt.Errorf("mapping entry must be a string, a table or false, got %T", value)
+	}
+}
+
+func (e *MappingEntry) validate() error {
+	if e.Destination == "" {
+		return errors.New("mapping option 'destination' is required")
+	}
+
+	switch e.Mode {
+	case "", ModeLink, ModeCopy, ModeTemplate:
+	default:
+		return fmt.Errorf("unknown mapping mode '%s': supported modes are %s, %s and %s", e.Mode, ModeLink, ModeCopy, ModeTemplate)
+	}
+
+	if err := CheckConflictPolicy(e.Conflict); err != nil {
+		return err
+	}
+
+	_, envPattern, _ := strings.Cut(e.Env, "=")
+	conditions := map[string]string{
+		"os":       e.OS,
+		"hostname": e.Hostname,
+		"arch":     e.Arch,
+		"env":      envPattern,
+	}
+	for key, pattern := range conditions {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("mapping option '%s' has invalid pattern '%s'", key, pattern)
+		}
+	}
+
+	return nil
 }
 
 // Files represents [files] section of a profile.
 type Files struct {
-	Excludes []string
+	Excludes []string `yaml:"excludes"`
+}
+
+// Install represents [install] section of a profile.
+type Install struct {
+	// RelativeLinks makes symlinks point to their sources by relative paths
+	// computed from the symlink directory, instead of absolute paths.
+	// It is nil if not set, so an included profile can turn it off.
+	RelativeLinks *bool `toml:"relative_links" json:"relative_links" yaml:"relative_links"`
+
+	// Conflict is the conflict policy: what to do with an existing file at
+	// a destination. Default is "backup".
+	Conflict string `toml:"conflict" json:"conflict" yaml:"conflict"`
+}
+
+// ProfileError is an error in a profile file.
+type ProfileError struct {
+	// File is the path to the profile file.
+	File string
+
+	// Line is the line number of the error, starting with 1.
+	// It is 0 if the line is unknown.
+	Line int
+
+	// Err is the error itself.
+	Err error
+}
+
+// Error returns the error as "file:line: message".
+func (e *ProfileError) Error() string {
+	return e.Diagnostic().String()
+}
+
+// Unwrap returns the underlying error.
+func (e *ProfileError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic returns the error as a check diagnostic.
+func (e *ProfileError) Diagnostic() Diagnostic {
+	return Diagnostic{File: e.File, Line: e.Line, Message: e.Err.Error()}
+}
+
+// keyError is an error about the value of a profile key.
+type keyError struct {
+	// key is the key path as written in the profile file.
+	key []string
+
+	err error
+}
+
+// keyErrorf returns a keyError for the key path with the formatted message.
+func keyErrorf(key []string, format string, args ...any) error {
+	return &keyError{key: key, err: fmt.Errorf(format, args...)}
+}
+
+// Error returns the message of the error.
+func (e *keyError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *keyError) Unwrap() error {
+	return e.err
 }
 
 // NewProfile returns a new Profile.
+// Files included by the profile are merged with it.
+// Errors in the profile files are returned as *ProfileError.
 func NewProfile(filename string) (*Profile, error) {
-	var data ProfileData
-	var err error
-
-	switch filepath.Ext(filename) {
-	case ".toml":
-		data, err = profileDataFromTOML(filename)
-	case ".json":
-		data, err = profileDataFromJSON(filename)
-	default:
-		err = fmt.Errorf("unknown profile file extension %s: supported extensions are .toml and .json", filename)
+	p := &Profile{
+		filepath: filename,
 	}
 
+	files := make(map[string]string)
+	data, err := p.load(filename, files, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err = processProfileData(data, filename)
+	p.data, err = processProfileData(data, filename)
 	if err != nil {
-		return nil, err
+		return nil, p.locateError(err, files)
 	}
 
-	return &Profile{
-		filepath: filename,
-		data:     data,
-	}, nil
+	// Mapping keys are expanded by now, so look up their origins by the
+	// expanded keys too.
+	expand := variableExpander(p.data.Variables)
+	p.origins = make(map[string]origin, len(files))
+	for key, file := range files {
+		expanded, _ := expand(key)
+		p.origins[expanded] = origin{file: file, key: key}
+	}
+	return p, nil
+}
+
+// mergeProfileData returns base overridden by over.
+//
+// Directories and the conflict policy set in over win. Mapping entries,
+// variables and roots are merged by key, over wins. A removed mapping entry in over
+// deletes the one from base, and an exclude "!name" in over deletes the
+// exclude "name". relative_links set in over wins too.
+func mergeProfileData(base, over ProfileData) ProfileData {
+	result := base
+	result.Include = nil
+
+	overrides := []struct {
+		dst *string
+		src string
+	}{
+		{&result.Directories.Dotfiles, over.Directories.Dotfiles},
+		{&result.Directories.Sources, over.Directories.Sources},
+		{&result.Directories.Destination, over.Directories.Destination},
+		{&result.Directories.Backup, over.Directories.Backup},
+		{&result.Install.Conflict, over.Install.Conflict},
+	}
+	for _, o := range overrides {
+		if o.src != "" {
+			*o.dst = o.src
+		}
+	}
+	if over.Install.RelativeLinks != nil {
+		result.Install.RelativeLinks = over.Install.RelativeLinks
+	}
+
+	result.Mapping = nil
+	for src, entry := range base.Mapping {
+		if result.Mapping == nil {
+			result.Mapping = make(map[string]MappingEntry)
+		}
+		result.Mapping[src] = entry
+	}
+	for src, entry := range over.Mapping {
+		if entry.Removed {
+			delete(result.Mapping, src)
+			continue
+		}
+		if result.Mapping == nil {
+			result.Mapping = make(map[string]MappingEntry)
+		}
+		result.Mapping[src] = entry
+	}
+
+	result.Variables = mergeValues(base.Variables, over.Variables)
+	result.Roots = mergeValues(base.Roots, over.Roots)
+
+	result.Files.Excludes = nil
+	for _, exclude := range append(append([]string{}, base.Files.Excludes...), over.Files.Excludes...) {
+		name, removed := strings.CutPrefix(exclude, "!")
+		excludes := result.Files.Excludes[:0]
+		for _, e := range result.Files.Excludes {
+			if e != name {
+				excludes = append(excludes, e)
+			}
+		}
+		result.Files.Excludes = excludes
+		if !removed {
+			result.Files.Excludes = append(result.Files.Excludes, name)
+		}
+	}
+
+	return result
+}
+
+// mergeValues returns values of base overridden by values of over.
+func mergeValues(base, over map[string]string) map[string]string {
+	var result map[string]string
+	for _, values := range []map[string]string{base, over} {
+		for name, value := range values {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[name] = value
+		}
+	}
+	return result
 }
 
 // Filepath returns the path to the profile file.
@@ -92,6 +528,25 @@ func (p Profile) Data() ProfileData {
 	return p.data
 }
 
+// RelativeLinks reports whether symlinks use relative targets unless
+// the mapping entry says otherwise.
+func (p Profile) RelativeLinks() bool {
+	return p.data.Install.RelativeLinks != nil && *p.data.Install.RelativeLinks
+}
+
+// Files returns the profile file and all files it includes, included first.
+// A file included several times is listed once.
+func (p Profile) Files() []string {
+	return p.files
+}
+
+// Origin returns the file the mapping entry with the src key comes from, and
+// the key as it is written in that file.
+func (p Profile) Origin(src string) (file, key string) {
+	o := p.origins[src]
+	return o.file, o.key
+}
+
 // DotfilesDir returns the dotfiles directory path.
 func (p Profile) DotfilesDir() string {
 	return p.data.Directories.Dotfiles
@@ -109,33 +564,221 @@ func (p Profile) DestinationDir() string {
 	return p.data.Directories.Destination
 }
 
+// DestinationPath returns the absolute path of the mapping destination.
+// Relative destinations are inside the destination directory.
+func (p Profile) DestinationPath(dest string) string {
+	if path.IsAbs(dest) {
+		return path.Clean(dest)
+	}
+	return path.Join(p.data.Directories.Destination, dest)
+}
+
+// MappingDestination returns the mapping destination for the absolute path:
+// the path relative to the destination directory if it is inside of it,
+// "root:path" for the deepest root it is inside of, or the path itself.
+func (p Profile) MappingDestination(destAbs string) string {
+	if IsInside(p.data.Directories.Destination, destAbs) {
+		rel, _ := filepath.Rel(p.data.Directories.Destination, destAbs)
+		if name, _, ok := strings.Cut(rel, ":"); ok && rootName.MatchString(name) {
+			// Do not let the colon be read as a root prefix.
+			return "./" + rel
+		}
+		return rel
+	}
+
+	names := make([]string, 0, len(p.data.Roots))
+	for name := range p.data.Roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dest, rootDir := destAbs, ""
+	for _, name := range names {
+		root := p.data.Roots[name]
+		if !IsInside(root, destAbs) || len(root) <= len(rootDir) {
+			continue
+		}
+		rel, _ := filepath.Rel(root, destAbs)
+		dest, rootDir = name+":"+rel, root
+	}
+	return dest
+}
+
 // BackupDir returns the backup directory path.
 func (p Profile) BackupDir() string {
 	return p.data.Directories.Backup
 }
 
-func profileDataFromTOML(filename string) (ProfileData, error) {
-	var data ProfileData
-	if _, err := toml.DecodeFile(filename, &data); err != nil {
+// load decodes the profile file and merges it over the files it includes.
+// origins gets the file of every mapping entry by its key. stack holds the
+// files being loaded to detect include cycles.
+func (p *Profile) load(filename string, origins map[string]string, stack []string) (ProfileData, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
 		return ProfileData{}, err
 	}
-	return data, nil
-}
+	for _, f := range stack {
+		if f == abs {
+			return ProfileData{}, fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+		}
+	}
+	stack = append(stack, abs)
 
-func profileDataFromJSON(filename string) (ProfileData, error) {
-	file, err := os.ReadFile(filename)
+	format, err := ProfileFormatFor(filename)
 	if err != nil {
 		return ProfileData{}, err
 	}
 
-	var data ProfileData
-	if err = json.Unmarshal(file, &data); err != nil {
+	content, err := os.ReadFile(filename)
+	if err != nil {
 		return ProfileData{}, err
 	}
-	return data, nil
+
+	var data ProfileData
+	if err = format.Decode(content, &data); err != nil {
+		return ProfileData{}, decodeError(filename, format, content, err)
+	}
+
+	var merged ProfileData
+	for _, include := range data.Include {
+		include = os.ExpandEnv(include)
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+
+		included, err := p.load(include, origins, stack)
+		var profileErr *ProfileError
+		if errors.As(err, &profileErr) {
+			return ProfileData{}, err
+		}
+		if err != nil {
+			return ProfileData{}, fmt.Errorf("%s: %w", include, err)
+		}
+		merged = mergeProfileData(merged, included)
+	}
+
+	if !slices.Contains(p.files, filename) {
+		p.files = append(p.files, filename)
+	}
+	for src := range data.Mapping {
+		origins[src] = filename
+	}
+	return mergeProfileData(merged, data), nil
+}
+
+// locateError returns the error of processing the profile data as
+// *ProfileError located at the key the error is about, if it is known.
+// Mapping entries are looked for in the files they come from, everything
+// else in the last file that has the key.
+func (p *Profile) locateError(err error, origins map[string]string) error {
+	var keyErr *keyError
+	if !errors.As(err, &keyErr) {
+		return &ProfileError{File: p.filepath, Err: err}
+	}
+
+	files := p.files
+	if len(keyErr.key) > 1 && keyErr.key[0] == "mapping" && origins[keyErr.key[1]] != "" {
+		files = []string{origins[keyErr.key[1]]}
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		format, formatErr := ProfileFormatFor(files[i])
+		content, readErr := os.ReadFile(files[i])
+		if formatErr != nil || readErr != nil {
+			continue
+		}
+		if line := format.Line(content, keyErr.key, ""); line != 0 {
+			return &ProfileError{File: files[i], Line: line, Err: err}
+		}
+	}
+	return &ProfileError{File: p.filepath, Err: err}
+}
+
+// decodeError returns the error of decoding the profile file as *ProfileError.
+// Decoders do not tell which mapping entry is invalid, so the entries are
+// checked one by one to report the invalid one with its key and line.
+func decodeError(filename string, format ProfileFormat, content []byte, err error) error {
+	if object, objectErr := format.Object(content); objectErr == nil {
+		mapping, _ := object["mapping"].(map[string]any)
+		keys := make([]string, 0, len(mapping))
+		for key := range mapping {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			var entry MappingEntry
+			if entryErr := entry.fromValue(mapping[key]); entryErr != nil {
+				return &ProfileError{
+					File: filename,
+					Line: format.Line(content, []string{"mapping", key}, ""),
+					Err:  fmt.Errorf("'mapping': entry '%s': %w", key, entryErr),
+				}
+			}
+		}
+	}
+	return &ProfileError{File: filename, Line: format.ErrorLine(content, err), Err: err}
+}
+
+func decodeTOMLProfile(content []byte, data *ProfileData) error {
+	_, err := toml.Decode(string(content), data)
+	return err
+}
+
+// tomlErrorLine returns the line number of the TOML decode error.
+func tomlErrorLine(_ []byte, err error) int {
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Position.Line
+	}
+	return 0
+}
+
+// tomlObject decodes the TOML content into a generic object.
+func tomlObject(content []byte) (map[string]any, error) {
+	var object map[string]any
+	_, err := toml.Decode(string(content), &object)
+	return object, err
+}
+
+func decodeJSONProfile(content []byte, data *ProfileData) error {
+	return json.Unmarshal(content, data)
+}
+
+// jsonObject decodes the JSON content into a generic object.
+func jsonObject(content []byte) (map[string]any, error) {
+	var object map[string]any
+	err := json.Unmarshal(content, &object)
+	return object, err
+}
+
+// jsonErrorLine returns the line number of the byte offset the JSON decode
+// error is reported at.
+func jsonErrorLine(content []byte, err error) int {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0
+	}
+	offset = min(offset, int64(len(content)))
+	return bytes.Count(content[:offset], []byte("\n")) + 1
 }
 
 func processProfileData(data ProfileData, profilePath string) (ProfileData, error) {
+	vars, err := resolveVariables(data.Variables)
+	if err != nil {
+		return ProfileData{}, fmt.Errorf("'variables': %w", err)
+	}
+	if vars != nil {
+		data.Variables = vars
+	}
+	expand := variableExpander(vars)
+
 	home := os.Getenv("HOME")
 	profileDir := path.Dir(profilePath)
 
@@ -167,18 +810,23 @@ func processProfileData(data ProfileData, profilePath string) (ProfileData, erro
 	}
 
 	for i := range dirs {
-		dirs[i].value = os.ExpandEnv(dirs[i].value)
+		if dirs[i].value, err = expand(dirs[i].value); err == nil {
+			dirs[i].value, err = expandTilde(dirs[i].value)
+		}
+		if err != nil {
+			return ProfileData{}, keyErrorf([]string{"directories", dirs[i].name}, "'directories.%s': %w", dirs[i].name, err)
+		}
 		if dirs[i].value == "" {
 			dirs[i].value = dirs[i].defaultValue
 		}
 
 		if dirs[i].relative {
 			if err := checkDirectoryRelative(dirs[i].name, dirs[i].value); err != nil {
-				return ProfileData{}, err
+				return ProfileData{}, &keyError{key: []string{"directories", dirs[i].name}, err: err}
 			}
 		} else {
 			if err := checkDirectoryAbsolute(dirs[i].name, dirs[i].value); err != nil {
-				return ProfileData{}, err
+				return ProfileData{}, &keyError{key: []string{"directories", dirs[i].name}, err: err}
 			}
 		}
 	}
@@ -188,9 +836,202 @@ func processProfileData(data ProfileData, profilePath string) (ProfileData, erro
 	data.Directories.Destination = dirs[2].value
 	data.Directories.Backup = dirs[3].value
 
+	for name, root := range data.Roots {
+		if root, err = expand(root); err == nil {
+			root, err = expandTilde(root)
+		}
+		if err != nil {
+			return ProfileData{}, keyErrorf([]string{"roots", name}, "'roots.%s': %w", name, err)
+		}
+		if !path.IsAbs(root) {
+			return ProfileData{}, keyErrorf([]string{"roots", name}, "'roots.%s' must be an absolute path", name)
+		}
+		data.Roots[name] = root
+	}
+
+	if data.Mapping != nil {
+		srcs := make([]string, 0, len(data.Mapping))
+		for src := range data.Mapping {
+			srcs = append(srcs, src)
+		}
+		sort.Strings(srcs)
+
+		mapping := make(map[string]MappingEntry, len(data.Mapping))
+		for _, src := range srcs {
+			entry := data.Mapping[src]
+			key, err := expand(src)
+			if err != nil {
+				return ProfileData{}, keyErrorf([]string{"mapping", src}, "'mapping': source '%s': %w", src, err)
+			}
+			if _, ok := mapping[key]; ok {
+				return ProfileData{}, keyErrorf([]string{"mapping", src}, "'mapping': source '%s' is mapped more than once", key)
+			}
+			if entry.Destination, err = expand(entry.Destination); err == nil {
+				entry.Destination, err = resolveDestination(entry.Destination, data.Directories.Destination, data.Roots)
+			}
+			if err != nil {
+				return ProfileData{}, keyErrorf([]string{"mapping", src}, "'mapping': destination of '%s': %w", key, err)
+			}
+			mapping[key] = entry
+		}
+		data.Mapping = mapping
+	}
+
+	if err := CheckConflictPolicy(data.Install.Conflict); err != nil {
+		return ProfileData{}, keyErrorf([]string{"install", "conflict"}, "'install.conflict': %w", err)
+	}
+
 	return data, nil
 }
 
+// resolveVariables returns the variables with references to other variables
+// and to environment variables expanded in their values.
+func resolveVariables(vars map[string]string) (map[string]string, error) {
+	if len(vars) == 0 {
+		return nil, nil
+	}
+
+	resolved := make(map[string]string, len(vars))
+	var resolve func(name string, stack []string) (string, error)
+	resolve = func(name string, stack []string) (string, error) {
+		if value, ok := resolved[name]; ok {
+			return value, nil
+		}
+		for i, n := range stack {
+			if n == name {
+				return "", fmt.Errorf("variable cycle: %s", strings.Join(append(stack[i:], name), " -> "))
+			}
+		}
+		stack = append(stack, name)
+
+		var err error
+		value := os.Expand(vars[name], func(ref string) string {
+			if err != nil {
+				return ""
+			}
+			if _, ok := vars[ref]; !ok {
+				value, ok := expandEnv(ref)
+				if !ok {
+					err = fmt.Errorf("undefined variable '%s'", ref)
+				}
+				return value
+			}
+			value, refErr := resolve(ref, stack)
+			err = refErr
+			return value
+		})
+		if err != nil {
+			return "", err
+		}
+		resolved[name] = value
+		return value, nil
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := resolve(name, nil); err != nil {
+			return nil, &keyError{key: []string{"variables", name}, err: err}
+		}
+	}
+	return resolved, nil
+}
+
+// variableExpander returns a function that replaces ${name} and $name in
+// a string with the variable, or with the environment variable if there is no
+// such variable. $$ is replaced with $. A name that is neither a variable nor
+// a set environment variable is an error, so that a typo does not silently
+// expand to an empty string.
+func variableExpander(vars map[string]string) func(string) (string, error) {
+	return func(s string) (string, error) {
+		var undefined []string
+		expanded := os.Expand(s, func(name string) string {
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			value, ok := expandEnv(name)
+			if !ok {
+				undefined = append(undefined, name)
+			}
+			return value
+		})
+		if len(undefined) > 0 {
+			return "", fmt.Errorf("undefined variable '%s'", undefined[0])
+		}
+		return expanded, nil
+	}
+}
+
+// xdgDefaults are the defaults of XDG base directories relative to the home
+// directory, used when the environment variables are unset.
+var xdgDefaults = map[string]string{
+	"XDG_CONFIG_HOME": ".config",
+	"XDG_DATA_HOME":   ".local/share",
+	"XDG_STATE_HOME":  ".local/state",
+}
+
+// expandEnv returns the environment variable and whether it is set, or $ for
+// the name "$" so that $$ stands for a literal $. Unset XDG base directories
+// get their defaults.
+func expandEnv(name string) (string, bool) {
+	if name == "$" {
+		return "$", true
+	}
+	value, ok := os.LookupEnv(name)
+	if def, isXDG := xdgDefaults[name]; isXDG && value == "" {
+		return path.Join(os.Getenv("HOME"), def), true
+	}
+	return value, ok
+}
+
+// expandTilde replaces ~ at the start of the path p with the home directory,
+// and ~user with the home directory of the user.
+func expandTilde(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
+	}
+
+	name, rest, _ := strings.Cut(p[1:], "/")
+	home := os.Getenv("HOME")
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("cannot expand ~%s: unknown user", name)
+		}
+		home = u.HomeDir
+	}
+	return path.Join(home, rest), nil
+}
+
+// rootName matches names a mapping destination can refer to roots by.
+var rootName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// resolveDestination replaces the "root:" prefix of the mapping destination
+// with the root and expands ~ in it. An absolute destination inside destDir
+// is made relative to it. A prefix that names no root is an error, so a
+// mistyped root is not installed as a file with a colon in its name.
+func resolveDestination(dest, destDir string, roots map[string]string) (string, error) {
+	if name, rest, ok := strings.Cut(dest, ":"); ok && rootName.MatchString(name) {
+		root, ok := roots[name]
+		if !ok {
+			return "", fmt.Errorf("unknown root '%s' in '%s', prefix the path with './' if the colon is a part of the file name", name, dest)
+		}
+		if !IsInside(root, path.Join(root, rest)) {
+			return "", fmt.Errorf("'%s' is outside of the root '%s' %s", dest, name, root)
+		}
+		dest = path.Join(root, rest)
+	}
+
+	dest, err := expandTilde(dest)
+	if err != nil || !path.IsAbs(dest) || !IsInside(destDir, dest) {
+		return dest, err
+	}
+	return filepath.Rel(destDir, dest)
+}
+
 type directory struct {
 	name         string
 	value        string
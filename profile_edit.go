@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	tomlMappingHeader      = regexp.MustCompile(`^\s*\[\s*mapping\s*\]\s*(#.*)?$`)
+	tomlMappingEntryHeader = regexp.MustCompile(`^\s*\[\s*mapping\s*\.\s*(.+?)\s*\]\s*(#.*)?$`)
+	tomlTableHeader        = regexp.MustCompile(`^\s*\[`)
+	tomlBareKey            = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// AddProfileMapping adds the "src" = "dest" entry to the mapping of the
+// profile file. The rest of the file, including comments, is kept as is.
+func AddProfileMapping(filename, src, dest string) error {
+	return editProfile(filename, func(format ProfileFormat, content []byte) ([]byte, error) {
+		return format.AddMapping(content, src, dest)
+	})
+}
+
+// RemoveProfileMapping removes the entry with the src key from the mapping
+// of the profile file. The rest of the file, including comments, is kept as is.
+func RemoveProfileMapping(filename, src string) error {
+	return editProfile(filename, func(format ProfileFormat, content []byte) ([]byte, error) {
+		return format.RemoveMapping(content, src)
+	})
+}
+
+// editProfile replaces the content of the profile file with the content
+// returned by the edit function.
+func editProfile(filename string, edit func(ProfileFormat, []byte) ([]byte, error)) error {
+	format, err := ProfileFormatFor(filename)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if content, err = edit(format, content); err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(filename, content, fi.Mode().Perm())
+}
+
+// addTOMLMapping inserts the entry after the last key of [mapping] section,
+// or appends the section if there is none.
+func addTOMLMapping(content []byte, src, dest string) ([]byte, error) {
+	entry := tomlQuote(src) + " = " + tomlQuote(dest)
+	lines := strings.Split(string(content), "\n")
+
+	header := -1
+	for i, line := range lines {
+		if tomlMappingHeader.MatchString(line) {
+			header = i
+			break
+		}
+	}
+
+	var result string
+	if header == -1 {
+		result = strings.TrimRight(string(content), "\n")
+		if result != "" {
+			result += "\n\n"
+		}
+		result += "[mapping]\n" + entry + "\n"
+	} else {
+		insert := header + 1
+		for i := header + 1; i < len(lines) && !tomlTableHeader.MatchString(lines[i]); i++ {
+			line := strings.TrimSpace(lines[i])
+			if line != "" && !strings.HasPrefix(line, "#") {
+				insert = i + 1
+			}
+		}
+
+		lines = append(lines[:insert], append([]string{entry}, lines[insert:]...)...)
+		result = strings.Join(lines, "\n")
+	}
+
+	// Make sure the profile is still valid and has the entry.
+	var data ProfileData
+	if _, err := toml.Decode(result, &data); err != nil {
+		return nil, fmt.Errorf("cannot add mapping to the profile: %w", err)
+	}
+	if data.Mapping[src].Destination != dest {
+		return nil, errors.New("cannot add mapping to the profile: unsupported mapping format")
+	}
+
+	return []byte(result), nil
+}
+
+// removeTOMLMapping removes the entry line from [mapping] section or the
+// [mapping."src"] table. The comment lines right above the entry are removed
+// too, unless another entry follows it: then they head a group of entries.
+func removeTOMLMapping(content []byte, src string) ([]byte, error) {
+	keys := tomlKeys(src)
+	lines := strings.Split(string(content), "\n")
+
+	var result []string
+	inMapping, inEntry := false, false
+	for i, line := range lines {
+		if tomlTableHeader.MatchString(line) {
+			inMapping = tomlMappingHeader.MatchString(line)
+			inEntry = false
+			if m := tomlMappingEntryHeader.FindStringSubmatch(line); m != nil && keys[m[1]] {
+				inEntry = true
+				result = trimTOMLComments(result)
+			}
+		}
+		if inEntry {
+			continue
+		}
+
+		if inMapping {
+			if key, _, ok := strings.Cut(strings.TrimSpace(line), "="); ok && keys[strings.TrimSpace(key)] {
+				if i+1 == len(lines) || !isTOMLKeyValue(lines[i+1]) {
+					result = trimTOMLComments(result)
+				}
+				continue
+			}
+		}
+		result = append(result, line)
+	}
+
+	// Make sure the profile is still valid and has no entry.
+	var before, after ProfileData
+	if _, err := toml.Decode(string(content), &before); err != nil {
+		return nil, fmt.Errorf("cannot remove mapping from the profile: %w", err)
+	}
+	if _, err := toml.Decode(strings.Join(result, "\n"), &after); err != nil {
+		return nil, fmt.Errorf("cannot remove mapping from the profile: %w", err)
+	}
+	if _, ok := after.Mapping[src]; ok || len(after.Mapping) != len(before.Mapping)-1 {
+		return nil, errors.New("cannot remove mapping from the profile: unsupported mapping format")
+	}
+
+	return []byte(strings.Join(result, "\n")), nil
+}
+
+// trimTOMLComments removes the trailing comment lines.
+func trimTOMLComments(lines []string) []string {
+	for len(lines) > 0 && strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), "#") {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isTOMLKeyValue reports whether the line is a key/value pair.
+func isTOMLKeyValue(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && !strings.HasPrefix(line, "#") && !tomlTableHeader.MatchString(line)
+}
+
+// tomlKeys returns all spellings of the key s in TOML.
+func tomlKeys(s string) map[string]bool {
+	keys := map[string]bool{tomlQuote(s): true}
+	if !strings.Contains(s, "'") {
+		keys["'"+s+"'"] = true
+	}
+	if tomlBareKey.MatchString(s) {
+		keys[s] = true
+	}
+	return keys
+}
+
+// tomlQuote returns s as a TOML basic string.
+func tomlQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// jsonField is a field of a JSON object that keeps the raw value.
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// addJSONMapping adds the entry to the end of "mapping" object, adding the
+// object if there is none. The order of all other fields is kept.
+func addJSONMapping(content []byte, src, dest string) ([]byte, error) {
+	fields, err := decodeJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	destValue, err := json.Marshal(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for i, field := range fields {
+		if field.Key != "mapping" {
+			continue
+		}
+
+		mapping, err := decodeJSONObject(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("mapping: %w", err)
+		}
+		mapping = setJSONField(mapping, src, destValue)
+		if fields[i].Value, err = encodeJSONObject(mapping); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+
+	if !found {
+		mapping, err := encodeJSONObject([]jsonField{{Key: src, Value: destValue}})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{Key: "mapping", Value: mapping})
+	}
+
+	return indentJSON(fields)
+}
+
+// removeJSONMapping removes the entry from "mapping" object.
+// The order of all other fields is kept.
+func removeJSONMapping(content []byte, src string) ([]byte, error) {
+	fields, err := decodeJSONObject(content)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for i, field := range fields {
+		if field.Key != "mapping" {
+			continue
+		}
+
+		mapping, err := decodeJSONObject(field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("mapping: %w", err)
+		}
+		for j := range mapping {
+			if mapping[j].Key == src {
+				mapping = append(mapping[:j], mapping[j+1:]...)
+				found = true
+				break
+			}
+		}
+		if fields[i].Value, err = encodeJSONObject(mapping); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot remove mapping from the profile: no entry for %s", src)
+	}
+
+	return indentJSON(fields)
+}
+
+// indentJSON encodes fields as an indented JSON object.
+func indentJSON(fields []jsonField) ([]byte, error) {
+	result, err := encodeJSONObject(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = json.Indent(&buf, result, "", "    "); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// decodeJSONObject decodes fields of a JSON object in their order.
+func decodeJSONObject(data []byte) ([]jsonField, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, errors.New("JSON object expected")
+	}
+
+	var fields []jsonField
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, errors.New("JSON object key expected")
+		}
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, jsonField{Key: key, Value: value})
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON object")
+	}
+
+	return fields, nil
+}
+
+// encodeJSONObject encodes fields as a compact JSON object.
+func encodeJSONObject(fields []jsonField) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		if err = json.Compact(&buf, field.Value); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// setJSONField replaces the value of the field with the key, or appends
+// a new field.
+func setJSONField(fields []jsonField, key string, value json.RawMessage) []jsonField {
+	for i := range fields {
+		if fields[i].Key == key {
+			fields[i].Value = value
+			return fields
+		}
+	}
+	return append(fields, jsonField{Key: key, Value: value})
+}
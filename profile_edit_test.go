@@ -0,0 +1,321 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddTOMLMapping(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name: "after last entry",
+			content: `# Profile.
+[directories]
+dotfiles = "$HOME/dotfiles"
+
+[mapping]
+# Vim
+"vim/vimrc" = ".vimrc" # main config
+
+# Files section.
+[files]
+excludes = ["README.md"]
+`,
+			expected: `# Profile.
+[directories]
+dotfiles = "$HOME/dotfiles"
+
+[mapping]
+# Vim
+"vim/vimrc" = ".vimrc" # main config
+"zshrc" = ".zshrc"
+
+# Files section.
+[files]
+excludes = ["README.md"]
+`,
+		},
+		{
+			name: "empty section",
+			content: `# Example:
+# "vim/vimrc" = ".vimrc"
+[mapping]
+`,
+			expected: `# Example:
+# "vim/vimrc" = ".vimrc"
+[mapping]
+"zshrc" = ".zshrc"
+`,
+		},
+		{
+			name: "no section",
+			content: `[directories]
+dotfiles = "$HOME/dotfiles"
+`,
+			expected: `[directories]
+dotfiles = "$HOME/dotfiles"
+
+[mapping]
+"zshrc" = ".zshrc"
+`,
+		},
+	}
+
+	for _, c := range cases {
+		result, err := addTOMLMapping([]byte(c.content), "zshrc", ".zshrc")
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.expected, string(result), c.name)
+	}
+}
+
+func TestAddTOMLMapping_Duplicate(t *testing.T) {
+	t.Parallel()
+
+	_, err := addTOMLMapping([]byte("[mapping]\n\"zshrc\" = \".zshrc\"\n"), "zshrc", ".zshrc")
+
+	assert.Error(t, err)
+}
+
+func TestRemoveTOMLMapping(t *testing.T) {
+	t.Parallel()
+
+	content := `[mapping]
+# Vim
+"vim/vimrc" = ".vimrc" # main config
+zshrc = ".zshrc"
+'git/config' = { destination = ".gitconfig", mode = "copy" }
+
+# Built by make
+[mapping."bin/tool"]
+destination = "bin/tool"
+mode = "copy"
+
+[files]
+excludes = ["README.md"]
+`
+
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{
+			src: "vim/vimrc",
+			expected: `[mapping]
+# Vim
+zshrc = ".zshrc"
+'git/config' = { destination = ".gitconfig", mode = "copy" }
+
+# Built by make
+[mapping."bin/tool"]
+destination = "bin/tool"
+mode = "copy"
+
+[files]
+excludes = ["README.md"]
+`,
+		},
+		{
+			src: "zshrc",
+			expected: `[mapping]
+# Vim
+"vim/vimrc" = ".vimrc" # main config
+'git/config' = { destination = ".gitconfig", mode = "copy" }
+
+# Built by make
+[mapping."bin/tool"]
+destination = "bin/tool"
+mode = "copy"
+
+[files]
+excludes = ["README.md"]
+`,
+		},
+		{
+			src: "git/config",
+			expected: `[mapping]
+# Vim
+"vim/vimrc" = ".vimrc" # main config
+zshrc = ".zshrc"
+
+# Built by make
+[mapping."bin/tool"]
+destination = "bin/tool"
+mode = "copy"
+
+[files]
+excludes = ["README.md"]
+`,
+		},
+		{
+			src: "bin/tool",
+			expected: `[mapping]
+# Vim
+"vim/vimrc" = ".vimrc" # main config
+zshrc = ".zshrc"
+'git/config' = { destination = ".gitconfig", mode = "copy" }
+
+[files]
+excludes = ["README.md"]
+`,
+		},
+	}
+
+	for _, c := range cases {
+		result, err := removeTOMLMapping([]byte(content), c.src)
+		require.NoError(t, err, c.src)
+		assert.Equal(t, c.expected, string(result), c.src)
+	}
+}
+
+func TestRemoveTOMLMapping_Comments(t *testing.T) {
+	t.Parallel()
+
+	content := `[mapping]
+# Shell files
+"zsh/zshrc" = ".zshrc"
+"zsh/zshenv" = ".zshenv"
+
+# Git
+# Both global and local
+"git/config" = ".gitconfig"
+# Tmux
+"tmux.conf" = ".tmux.conf"
+`
+
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{
+			// The comment heads the following entry too.
+			src: "zsh/zshrc",
+			expected: `[mapping]
+# Shell files
+"zsh/zshenv" = ".zshenv"
+
+# Git
+# Both global and local
+"git/config" = ".gitconfig"
+# Tmux
+"tmux.conf" = ".tmux.conf"
+`,
+		},
+		{
+			src: "git/config",
+			expected: `[mapping]
+# Shell files
+"zsh/zshrc" = ".zshrc"
+"zsh/zshenv" = ".zshenv"
+
+# Tmux
+"tmux.conf" = ".tmux.conf"
+`,
+		},
+		{
+			src: "tmux.conf",
+			expected: `[mapping]
+# Shell files
+"zsh/zshrc" = ".zshrc"
+"zsh/zshenv" = ".zshenv"
+
+# Git
+# Both global and local
+"git/config" = ".gitconfig"
+`,
+		},
+	}
+
+	for _, c := range cases {
+		result, err := removeTOMLMapping([]byte(content), c.src)
+		require.NoError(t, err, c.src)
+		assert.Equal(t, c.expected, string(result), c.src)
+	}
+}
+
+func TestRemoveTOMLMapping_NotFound(t *testing.T) {
+	t.Parallel()
+
+	_, err := removeTOMLMapping([]byte("[mapping]\n\"zshrc\" = \".zshrc\"\n"), "bashrc")
+
+	assert.Error(t, err)
+}
+
+func TestAddJSONMapping(t *testing.T) {
+	t.Parallel()
+
+	content := `{
+  "directories": {"dotfiles": "/dotfiles", "destination": "/home"},
+  "mapping": {
+    "vim/vimrc": ".vimrc",
+    "git/config": {"destination": ".gitconfig", "mode": "copy"}
+  }
+}`
+
+	result, err := addJSONMapping([]byte(content), "zshrc", ".zshrc")
+
+	require.NoError(t, err)
+	assert.Equal(t, `{
+    "directories": {
+        "dotfiles": "/dotfiles",
+        "destination": "/home"
+    },
+    "mapping": {
+        "vim/vimrc": ".vimrc",
+        "git/config": {
+            "destination": ".gitconfig",
+            "mode": "copy"
+        },
+        "zshrc": ".zshrc"
+    }
+}
+`, string(result))
+}
+
+func TestRemoveJSONMapping(t *testing.T) {
+	t.Parallel()
+
+	content := `{"mapping": {"vim/vimrc": ".vimrc", "zshrc": ".zshrc"}, "files": {"excludes": []}}`
+
+	result, err := removeJSONMapping([]byte(content), "vim/vimrc")
+
+	require.NoError(t, err)
+	assert.Equal(t, `{
+    "mapping": {
+        "zshrc": ".zshrc"
+    },
+    "files": {
+        "excludes": []
+    }
+}
+`, string(result))
+
+	_, err = removeJSONMapping([]byte(content), "bashrc")
+	assert.Error(t, err)
+}
+
+func TestAddProfileMapping(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "dotbro.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"directories": {"dotfiles": "/dotfiles"}}`), 0640))
+
+	require.NoError(t, AddProfileMapping(filename, "vim/vimrc", ".vimrc"))
+
+	p, err := NewProfile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]MappingEntry{"vim/vimrc": {Destination: ".vimrc"}}, p.Data().Mapping)
+
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
 	"testing"
 
@@ -27,6 +29,15 @@ func TestNewProfile_FromTOML(t *testing.T) {
 	assert.Equal(t, "/dotfiles/root", p.DotfilesDir())
 }
 
+func TestNewProfile_FromYAML(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewProfile("testdata/profile_valid.yaml")
+
+	require.NoError(t, err)
+	assert.Equal(t, "/dotfiles/root", p.DotfilesDir())
+}
+
 func TestNewProfile_InvalidJSON(t *testing.T) {
 	t.Parallel()
 
@@ -43,12 +54,20 @@ func TestNewProfile_InvalidTOML(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestNewProfile_InvalidYAML(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/profile_invalid.yaml")
+
+	assert.Error(t, err)
+}
+
 func TestNewProfile_UnknownExtension(t *testing.T) {
 	t.Parallel()
 
 	_, err := NewProfile("testdata/somefile.badext")
 
-	assert.Error(t, err)
+	assert.EqualError(t, err, "unknown profile file extension testdata/somefile.badext: supported extensions are .toml, .json, .yaml and .yml")
 }
 
 func TestNewProfile_BadDotfilesDirectory(t *testing.T) {
@@ -92,12 +111,14 @@ func TestNewProfile_ExpandEnv(t *testing.T) {
 	t.Setenv("TEST_DESTINATION_DIR", "/my/destination")
 	t.Setenv("TEST_BACKUP_DIR", "/my/backup")
 
-	p, err := NewProfile("testdata/profile_env_vars.json")
+	for _, filename := range []string{"testdata/profile_env_vars.json", "testdata/profile_env_vars.yaml"} {
+		p, err := NewProfile(filename)
 
-	require.NoError(t, err)
-	assert.Equal(t, "/my/dotfiles", p.DotfilesDir())
-	assert.Equal(t, "/my/destination", p.DestinationDir())
-	assert.Equal(t, "/my/backup", p.BackupDir())
+		require.NoError(t, err, filename)
+		assert.Equal(t, "/my/dotfiles", p.DotfilesDir(), filename)
+		assert.Equal(t, "/my/destination", p.DestinationDir(), filename)
+		assert.Equal(t, "/my/backup", p.BackupDir(), filename)
+	}
 }
 
 func TestNewProfile_DefaultValues(t *testing.T) {
@@ -114,3 +135,602 @@ func TestNewProfile_DefaultValues(t *testing.T) {
 	assert.Equal(t, home, p.DestinationDir())
 	assert.Equal(t, home+"/.dotfiles~", p.BackupDir())
 }
+
+func TestNewProfile_Mapping(t *testing.T) {
+	t.Parallel()
+
+	for _, filename := range []string{"testdata/profile_mapping.toml", "testdata/profile_mapping.json", "testdata/profile_mapping.yml"} {
+		p, err := NewProfile(filename)
+
+		require.NoError(t, err, filename)
+		assert.Equal(t, map[string]MappingEntry{
+			"vim/vimrc":  {Destination: ".vimrc"},
+			"git/config": {Destination: ".gitconfig", Mode: ModeCopy},
+		}, p.Data().Mapping, filename)
+	}
+}
+
+func TestNewProfile_BadMapping(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/profile_bad_mapping.toml")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown mapping mode 'hardlink'")
+}
+
+func TestMappingEntry_UnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	relative := false
+	cases := []struct {
+		data          string
+		expected      MappingEntry
+		expectedError string
+	}{
+		{
+			data:     `".vimrc"`,
+			expected: MappingEntry{Destination: ".vimrc"},
+		},
+		{
+			data:     `{"destination": ".vimrc", "mode": "link"}`,
+			expected: MappingEntry{Destination: ".vimrc", Mode: ModeLink},
+		},
+		{
+			data:     `{"destination": ".vimrc", "relative": false}`,
+			expected: MappingEntry{Destination: ".vimrc", Relative: &relative},
+		},
+		{
+			data:          `{"mode": "copy"}`,
+			expectedError: "mapping option 'destination' is required",
+		},
+		{
+			data:          `{"destination": ".vimrc", "bogus": 1}`,
+			expectedError: "unknown mapping option 'bogus'",
+		},
+		{
+			data:          `{"destination": 1}`,
+			expectedError: "mapping option 'destination' has invalid type float64",
+		},
+		{
+			data:     `{"destination": ".gitconfig", "os": "linux", "hostname": "work-*", "arch": "amd64", "env": "CI=true"}`,
+			expected: MappingEntry{Destination: ".gitconfig", OS: "linux", Hostname: "work-*", Arch: "amd64", Env: "CI=true"},
+		},
+		{
+			data:     `{"destination": ".vimrc", "conflict": "skip"}`,
+			expected: MappingEntry{Destination: ".vimrc", Conflict: ConflictSkip},
+		},
+		{
+			data:          `{"destination": ".vimrc", "conflict": "nuke"}`,
+			expectedError: "unknown conflict policy 'nuke': supported policies are backup, skip, overwrite, fail, adopt and prompt",
+		},
+		{
+			data:          `{"destination": ".gitconfig", "hostname": "work-["}`,
+			expectedError: "mapping option 'hostname' has invalid pattern 'work-['",
+		},
+		{
+			data:     `false`,
+			expected: MappingEntry{Removed: true},
+		},
+		{
+			data:          `true`,
+			expectedError: "mapping entry must be a string, a table or false",
+		},
+		{
+			data:          `42`,
+			expectedError: "mapping entry must be a string, a table or false, got float64",
+		},
+	}
+
+	for _, c := range cases {
+		var entry MappingEntry
+		err := entry.UnmarshalJSON([]byte(c.data))
+
+		if c.expectedError != "" {
+			assert.EqualError(t, err, c.expectedError, c.data)
+			continue
+		}
+		require.NoError(t, err, c.data)
+		assert.Equal(t, c.expected, entry, c.data)
+	}
+}
+
+func TestNewProfile_RelativeLinks(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewProfile("testdata/profile_relative_links.toml")
+	require.NoError(t, err)
+
+	assert.True(t, p.RelativeLinks())
+	assert.True(t, p.Data().Mapping["vim/vimrc"].RelativeLinks(p.RelativeLinks()))
+	assert.False(t, p.Data().Mapping["zsh/zshrc"].RelativeLinks(p.RelativeLinks()))
+}
+
+func TestNewProfile_Template(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewProfile("testdata/profile_template.toml")
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]MappingEntry{
+		"git/config.tmpl": {Destination: ".gitconfig", Mode: ModeTemplate},
+	}, p.Data().Mapping)
+	assert.Equal(t, map[string]string{"email": "user@example.com"}, p.Data().Variables)
+	assert.True(t, p.Data().Mapping["git/config.tmpl"].HasContent())
+}
+
+func TestNewProfile_Variables(t *testing.T) {
+	t.Setenv("TEST_VARIABLES_HOME", "/home/me")
+
+	p, err := NewProfile("testdata/profile_variables.toml")
+
+	require.NoError(t, err)
+	assert.Equal(t, "/home/me/dotfiles", p.DotfilesDir())
+	assert.Equal(t, "/home/me", p.DestinationDir())
+	assert.Equal(t, map[string]MappingEntry{
+		"vim/vimrc":   {Destination: ".config/vim/vimrc"},
+		"laptop/nvim": {Destination: ".config/nvim"},
+		"price$":      {Destination: "price"},
+	}, p.Data().Mapping)
+	assert.Equal(t, map[string]string{
+		"home":       "/home/me",
+		"dotfiles":   "/home/me/dotfiles",
+		"xdg_config": ".config",
+		"vim_dir":    ".config/vim",
+		"host":       "laptop",
+	}, p.Data().Variables)
+
+	file, key := p.Origin("laptop/nvim")
+	assert.Equal(t, "testdata/profile_variables.toml", file)
+	assert.Equal(t, "${host}/nvim", key)
+}
+
+func TestNewProfile_VariablesCycle(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/profile_variables_cycle.toml")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "'variables': variable cycle: a -> b -> c -> a")
+}
+
+func TestNewProfile_UndefinedVariable(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		content string
+		line    int
+		err     string
+	}{
+		"mapping destination": {
+			content: "[mapping]\nvimrc = \".vimrc\"\nnvim = \"${xdg_confg}/nvim\"\n\n[variables]\nxdg_config = \".config\"\n",
+			line:    3,
+			err:     "'mapping': destination of 'nvim': undefined variable 'xdg_confg'",
+		},
+		"mapping source": {
+			content: "[mapping]\n\"${hots}/vimrc\" = \".vimrc\"\n",
+			line:    2,
+			err:     "'mapping': source '${hots}/vimrc': undefined variable 'hots'",
+		},
+		"directory": {
+			content: "[directories]\ndotfiles = \"$DOTBRO_TEST_UNDEFINED/dotfiles\"\n",
+			line:    2,
+			err:     "'directories.dotfiles': undefined variable 'DOTBRO_TEST_UNDEFINED'",
+		},
+		"variable": {
+			content: "[variables]\nhome = \"${hme}\"\n",
+			line:    2,
+			err:     "'variables': undefined variable 'hme'",
+		},
+	}
+
+	for name, c := range cases {
+		filename := filepath.Join(t.TempDir(), "dotbro.toml")
+		require.NoError(t, os.WriteFile(filename, []byte(c.content), 0600))
+
+		_, err := NewProfile(filename)
+
+		var profileErr *ProfileError
+		require.ErrorAs(t, err, &profileErr, name)
+		assert.Equal(t, c.line, profileErr.Line, name)
+		assert.Contains(t, err.Error(), c.err, name)
+	}
+}
+
+func TestNewProfile_LiteralDollar(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "dotbro.toml")
+	content := "[mapping]\n\"price\" = \"$$HOME/price\"\n"
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+
+	profile, err := NewProfile(filename)
+
+	require.NoError(t, err)
+	assert.Equal(t, "$HOME/price", profile.Data().Mapping["price"].Destination)
+}
+
+func TestNewProfile_VariablesDuplicateSource(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/profile_variables_duplicate.toml")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "'mapping': source 'laptop/vimrc' is mapped more than once")
+}
+
+func TestNewProfile_Tilde(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_DATA_HOME", "/home/me/data")
+	t.Setenv("XDG_STATE_HOME", "")
+	root, err := user.Lookup("root")
+	require.NoError(t, err)
+
+	p, err := NewProfile("testdata/profile_tilde.toml")
+
+	require.NoError(t, err)
+	assert.Equal(t, "/home/me/dotfiles", p.DotfilesDir())
+	assert.Equal(t, "/home/me", p.DestinationDir())
+	assert.Equal(t, path.Join(root.HomeDir, "backups"), p.BackupDir())
+	assert.Equal(t, map[string]MappingEntry{
+		"vim/vimrc":    {Destination: ".vimrc"},
+		"nvim":         {Destination: ".config/nvim"},
+		"fonts":        {Destination: "data/fonts"},
+		"less/history": {Destination: ".local/state/less/history"},
+		"zsh/zshrc":    {Destination: ".zshrc"},
+	}, p.Data().Mapping)
+}
+
+func TestNewProfile_AbsoluteDestination(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("XDG_DATA_HOME", "/data")
+
+	p, err := NewProfile("testdata/profile_tilde.toml")
+
+	require.NoError(t, err)
+	assert.Equal(t, MappingEntry{Destination: "/data/fonts"}, p.Data().Mapping["fonts"])
+	assert.Equal(t, "/data/fonts", p.DestinationPath(p.Data().Mapping["fonts"].Destination))
+	assert.Equal(t, "/home/me/.vimrc", p.DestinationPath(p.Data().Mapping["vim/vimrc"].Destination))
+}
+
+func TestNewProfile_Roots(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg/config")
+
+	p, err := NewProfile("testdata/profile_roots.toml")
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"config": "/xdg/config",
+		"data":   "/data/share",
+		"home":   "/home/me",
+	}, p.Data().Roots)
+	assert.Equal(t, map[string]MappingEntry{
+		"nvim":      {Destination: "/xdg/config/nvim"},
+		"fonts":     {Destination: "/data/share/fonts"},
+		"vim/vimrc": {Destination: ".vimrc"},
+		"hosts":     {Destination: "/etc/hosts"},
+		"weird":     {Destination: "./nope:weird"},
+	}, p.Data().Mapping)
+}
+
+func TestNewProfile_BadRoot(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/profile_bad_root.toml")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "'roots.config' must be an absolute path")
+}
+
+func TestProfile_MappingDestination(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg/config")
+
+	p, err := NewProfile("testdata/profile_roots.toml")
+	require.NoError(t, err)
+
+	assert.Equal(t, ".vimrc", p.MappingDestination("/home/me/.vimrc"))
+	assert.Equal(t, "config:nvim/init.lua", p.MappingDestination("/xdg/config/nvim/init.lua"))
+	assert.Equal(t, "data:fonts", p.MappingDestination("/data/share/fonts"))
+	assert.Equal(t, "/etc/hosts", p.MappingDestination("/etc/hosts"))
+	assert.Equal(t, "./nope:weird", p.MappingDestination("/home/me/nope:weird"))
+}
+
+func TestNewProfile_UnknownRoot(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "dotbro.toml")
+	require.NoError(t, os.WriteFile(filename, []byte(`[directories]
+dotfiles = "/dotfiles"
+destination = "/home/me"
+
+[roots]
+config = "/home/me/.config"
+
+[mapping]
+"nvim" = "cfg:nvim"
+`), 0600))
+
+	_, err := NewProfile(filename)
+
+	assert.ErrorContains(t, err, "'mapping': destination of 'nvim': unknown root 'cfg'")
+}
+
+func TestNewProfile_RootEscape(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "dotbro.toml")
+	require.NoError(t, os.WriteFile(filename, []byte(`[directories]
+dotfiles = "/dotfiles"
+destination = "/home/me"
+
+[roots]
+config = "/home/me/.config"
+
+[mapping]
+"nvim" = "config:../../../etc/nvim"
+`), 0600))
+
+	_, err := NewProfile(filename)
+
+	var profileErr *ProfileError
+	require.ErrorAs(t, err, &profileErr)
+	assert.Equal(t, 9, profileErr.Line)
+	assert.ErrorContains(t, err, "'mapping': destination of 'nvim': 'config:../../../etc/nvim' is outside of the root 'config' /home/me/.config")
+}
+
+func TestNewProfile_BadTilde(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/profile_bad_tilde.toml")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "'directories.dotfiles': cannot expand ~nosuchuser-dotbro: unknown user")
+}
+
+func TestMappingEntry_Matches(t *testing.T) {
+	t.Setenv("DOTBRO_TEST_SESSION", "wayland")
+	t.Setenv("DOTBRO_TEST_EMPTY", "")
+
+	m := Machine{Hostname: "work-laptop", OS: "linux", Arch: "amd64"}
+
+	cases := []struct {
+		entry    MappingEntry
+		expected bool
+	}{
+		{entry: MappingEntry{}, expected: true},
+		{entry: MappingEntry{OS: "linux"}, expected: true},
+		{entry: MappingEntry{OS: "darwin"}, expected: false},
+		{entry: MappingEntry{Hostname: "work-*"}, expected: true},
+		{entry: MappingEntry{Hostname: "home-*"}, expected: false},
+		{entry: MappingEntry{Arch: "arm64"}, expected: false},
+		{entry: MappingEntry{OS: "linux", Arch: "arm64"}, expected: false},
+		{entry: MappingEntry{Env: "DOTBRO_TEST_SESSION"}, expected: true},
+		{entry: MappingEntry{Env: "DOTBRO_TEST_EMPTY"}, expected: false},
+		{entry: MappingEntry{Env: "DOTBRO_TEST_UNSET"}, expected: false},
+		{entry: MappingEntry{Env: "DOTBRO_TEST_SESSION=way*"}, expected: true},
+		{entry: MappingEntry{Env: "DOTBRO_TEST_SESSION=x11"}, expected: false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.entry.Matches(m), "%+v", c.entry)
+	}
+}
+
+func TestNewProfile_Conflict(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewProfile("testdata/profile_conflict.toml")
+	require.NoError(t, err)
+
+	assert.Equal(t, ConflictSkip, p.Data().Install.Conflict)
+	assert.Equal(t, "", p.Data().Mapping["vim/vimrc"].Conflict)
+	assert.Equal(t, ConflictAdopt, p.Data().Mapping["zsh/zshrc"].Conflict)
+
+	_, err = NewProfile("testdata/profile_bad_conflict.toml")
+	assert.ErrorContains(t, err, "'install.conflict': unknown conflict policy 'nuke'")
+}
+
+func TestNewProfile_Include(t *testing.T) {
+	t.Parallel()
+
+	p, err := NewProfile("testdata/include/profile.toml")
+
+	require.NoError(t, err)
+	assert.Equal(t, "/dotfiles/root", p.DotfilesDir())
+	assert.Equal(t, "/home/linux", p.DestinationDir())
+	assert.Equal(t, map[string]MappingEntry{
+		"vim/vimrc":  {Destination: ".vimrc"},
+		"git/config": {Destination: ".config/git/config"},
+		"sway":       {Destination: ".config/sway"},
+	}, p.Data().Mapping)
+	assert.Equal(t, []string{"LICENSE", "dotbro.toml"}, p.Data().Files.Excludes)
+	assert.Equal(t, map[string]string{"email": "me@example.com", "editor": "vim"}, p.Data().Variables)
+	assert.True(t, p.RelativeLinks())
+	assert.Equal(t, ConflictSkip, p.Data().Install.Conflict)
+
+	assert.Equal(t, []string{
+		"testdata/include/base.toml",
+		"testdata/include/linux.yaml",
+		"testdata/include/profile.toml",
+	}, p.Files())
+	file, key := p.Origin("git/config")
+	assert.Equal(t, "testdata/include/profile.toml", file)
+	assert.Equal(t, "git/config", key)
+	file, key = p.Origin("sway")
+	assert.Equal(t, "testdata/include/linux.yaml", file)
+	assert.Equal(t, "sway", key)
+}
+
+func TestNewProfile_IncludeCycle(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/include/cycle_a.json")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle: ")
+	assert.Contains(t, err.Error(), "cycle_a.json -> ")
+	assert.Contains(t, err.Error(), "cycle_b.json -> ")
+}
+
+func TestNewProfile_IncludeMissing(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewProfile("testdata/include/missing.toml")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nope.toml")
+}
+
+func TestNewProfile_ErrorLine(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		content string
+		line    int
+	}{
+		"dotbro.toml": {
+			content: "[directories]\ndotfiles = \"dotfiles\"\ndestination = [\n",
+			line:    3,
+		},
+		"dotbro.json": {
+			content: "{\n  \"directories\": {\n    \"dotfiles\": 1\n  }\n}\n",
+			line:    3,
+		},
+		"dotbro.yaml": {
+			content: "directories:\n  dotfiles: dotfiles\n  destination: [\n",
+			line:    3,
+		},
+		"dotbro.yml": {
+			content: "directories:\n  dotfiles: dotfiles\n  destination:\n    - home\n",
+			line:    4,
+		},
+	}
+
+	for name, c := range cases {
+		filename := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(filename, []byte(c.content), 0600))
+
+		_, err := NewProfile(filename)
+
+		var profileErr *ProfileError
+		require.ErrorAs(t, err, &profileErr, name)
+		assert.Equal(t, filename, profileErr.File, name)
+		assert.Equal(t, c.line, profileErr.Line, name)
+	}
+}
+
+func TestNewProfile_ErrorInInclude(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.toml")
+	require.NoError(t, os.WriteFile(base, []byte("[install]\n\nconflict = [\n"), 0600))
+	filename := filepath.Join(dir, "dotbro.toml")
+	require.NoError(t, os.WriteFile(filename, []byte("include = [\"base.toml\"]\n"), 0600))
+
+	_, err := NewProfile(filename)
+
+	var profileErr *ProfileError
+	require.ErrorAs(t, err, &profileErr)
+	assert.Equal(t, base, profileErr.File)
+	assert.Equal(t, 3, profileErr.Line)
+}
+
+func TestNewProfile_ValueErrorLine(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		content string
+		line    int
+		err     string
+	}{
+		"relative destination": {
+			content: "[directories]\ndotfiles = \"/dotfiles\"\ndestination = \"home\"\n",
+			line:    3,
+			err:     "'directories.destination' must be an absolute path",
+		},
+		"unknown mode": {
+			content: "[mapping]\nvimrc = \".vimrc\"\nzshrc = { destination = \".zshrc\", mode = \"cpy\" }\n",
+			line:    3,
+			err:     "'mapping': entry 'zshrc': unknown mapping mode 'cpy'",
+		},
+		"unknown root": {
+			content: "[mapping]\nvimrc = \".vimrc\"\nnvim = \"config:nvim\"\n",
+			line:    3,
+			err:     "'mapping': destination of 'nvim'",
+		},
+		"variable cycle": {
+			content: "[variables]\nx = \"y\"\na = \"${b}\"\nb = \"${a}\"\n",
+			line:    3,
+			err:     "variable cycle: a -> b -> a",
+		},
+		"duplicate source": {
+			content: "[variables]\nhost = \"laptop\"\n\n[mapping]\n\"${host}/vimrc\" = \".vimrc\"\n\"laptop/vimrc\" = \".vimrc2\"\n",
+			line:    6,
+			err:     "source 'laptop/vimrc' is mapped more than once",
+		},
+	}
+
+	for name, c := range cases {
+		filename := filepath.Join(t.TempDir(), "dotbro.toml")
+		require.NoError(t, os.WriteFile(filename, []byte(c.content), 0600))
+
+		_, err := NewProfile(filename)
+
+		var profileErr *ProfileError
+		require.ErrorAs(t, err, &profileErr, name)
+		assert.Equal(t, filename, profileErr.File, name)
+		assert.Equal(t, c.line, profileErr.Line, name)
+		assert.Contains(t, err.Error(), c.err, name)
+	}
+}
+
+func TestNewProfile_IncludeRelativeLinks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.toml")
+	require.NoError(t, os.WriteFile(base, []byte("[install]\nrelative_links = true\n"), 0600))
+
+	cases := map[string]bool{
+		"include = [\"base.toml\"]\n":                                    true,
+		"include = [\"base.toml\"]\n[install]\nrelative_links = false\n": false,
+		"[install]\nconflict = \"skip\"\n":                               false,
+	}
+	for content, expected := range cases {
+		filename := filepath.Join(dir, "dotbro.toml")
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+
+		p, err := NewProfile(filename)
+		require.NoError(t, err, content)
+		assert.Equal(t, expected, p.RelativeLinks(), content)
+	}
+}
+
+func TestNewProfile_IncludeDiamond(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"common.toml":  "[files]\nexcludes = [\"LICENSE\"]\n",
+		"linux.toml":   "include = [\"common.toml\"]\n",
+		"desktop.toml": "include = [\"common.toml\"]\n",
+		"dotbro.toml":  "include = [\"linux.toml\", \"desktop.toml\"]\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	p, err := NewProfile(filepath.Join(dir, "dotbro.toml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		filepath.Join(dir, "common.toml"),
+		filepath.Join(dir, "linux.toml"),
+		filepath.Join(dir, "desktop.toml"),
+		filepath.Join(dir, "dotbro.toml"),
+	}, p.Files())
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+func decodeYAMLProfile(content []byte, data *ProfileData) error {
+	return yaml.Unmarshal(content, data)
+}
+
+// yamlErrorLinePattern matches the line number yaml errors are prefixed with.
+var yamlErrorLinePattern = regexp.MustCompile(`line (\d+):`)
+
+// yamlErrorLine returns the line number of the YAML decode error, the first
+// one if there are several.
+func yamlErrorLine(_ []byte, err error) int {
+	m := yamlErrorLinePattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// yamlObject decodes the YAML content into a generic object.
+func yamlObject(content []byte) (map[string]any, error) {
+	var object map[string]any
+	err := yaml.Unmarshal(content, &object)
+	return object, err
+}
+
+// yamlUnknownKeys returns paths of keys in the YAML content that profiles do not support.
+func yamlUnknownKeys(content []byte) ([][]string, error) {
+	object, err := yamlObject(content)
+	if err != nil {
+		return nil, err
+	}
+	return unknownProfileKeys(object, nil, func(a, b string) bool { return a == b }), nil
+}
+
+// yamlLine returns the line number of the key path in the YAML content, or of
+// the string value of the key path. It returns 0 if nothing is found.
+func yamlLine(content []byte, key []string, value string) int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 {
+		return 0
+	}
+
+	node, line := doc.Content[0], 0
+	for _, name := range key {
+		keyNode, valueNode := yamlMapEntry(node, name)
+		if keyNode == nil {
+			return 0
+		}
+		node, line = valueNode, keyNode.Line
+	}
+
+	if value == "" {
+		return line
+	}
+	for _, item := range node.Content {
+		if item.Kind == yaml.ScalarNode && item.Value == value {
+			return item.Line
+		}
+	}
+	return 0
+}
+
+// addYAMLMapping adds the entry to the end of "mapping" map, adding the map if
+// there is none. Comments are kept, but the document is reformatted.
+func addYAMLMapping(content []byte, src, dest string) ([]byte, error) {
+	doc, err := decodeYAMLDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+
+	_, mapping := yamlMapEntry(root, "mapping")
+	switch {
+	case mapping == nil:
+		mapping = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, yamlString("mapping"), mapping)
+	case mapping.Kind == yaml.ScalarNode && mapping.Tag == "!!null":
+		*mapping = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: mapping.HeadComment, LineComment: mapping.LineComment}
+	case mapping.Kind != yaml.MappingNode:
+		return nil, errors.New("cannot add mapping to the profile: 'mapping' must be a map")
+	}
+
+	if keyNode, _ := yamlMapEntry(mapping, src); keyNode != nil {
+		return nil, fmt.Errorf("cannot add mapping to the profile: '%s' is already mapped", src)
+	}
+	mapping.Content = append(mapping.Content, yamlString(src), yamlString(dest))
+
+	result, err := encodeYAMLDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the profile is still valid and has the entry.
+	var data ProfileData
+	if err = yaml.Unmarshal(result, &data); err != nil {
+		return nil, fmt.Errorf("cannot add mapping to the profile: %w", err)
+	}
+	if data.Mapping[src].Destination != dest {
+		return nil, errors.New("cannot add mapping to the profile: unsupported mapping format")
+	}
+
+	return result, nil
+}
+
+// removeYAMLMapping removes the entry from "mapping" map.
+// Comments are kept, but the document is reformatted.
+func removeYAMLMapping(content []byte, src string) ([]byte, error) {
+	doc, err := decodeYAMLDocument(content)
+	if err != nil {
+		return nil, err
+	}
+
+	_, mapping := yamlMapEntry(doc.Content[0], "mapping")
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("cannot remove mapping from the profile: no entry for %s", src)
+	}
+
+	found := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == src {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot remove mapping from the profile: no entry for %s", src)
+	}
+
+	return encodeYAMLDocument(doc)
+}
+
+// decodeYAMLDocument decodes the YAML content into a document node with
+// a map at its root. Empty content results in an empty map.
+func decodeYAMLDocument(content []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("YAML map expected")
+	}
+	return &doc, nil
+}
+
+// encodeYAMLDocument encodes the document node with 2 spaces indentation.
+func encodeYAMLDocument(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlMapEntry returns the key and the value nodes of the map node entry
+// with the name, or nils.
+func yamlMapEntry(node *yaml.Node, name string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// yamlString returns a node of the string s.
+func yamlString(s string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddYAMLMapping(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name: "existing map",
+			content: `# Profile.
+directories:
+  dotfiles: /dotfiles
+
+# Vim
+mapping:
+  vim/vimrc: .vimrc # main config
+`,
+			expected: `# Profile.
+directories:
+  dotfiles: /dotfiles
+# Vim
+mapping:
+  vim/vimrc: .vimrc # main config
+  "true": .zshrc
+`,
+		},
+		{
+			name: "empty map",
+			content: `mapping:
+`,
+			expected: `mapping:
+  "true": .zshrc
+`,
+		},
+		{
+			name:    "no map",
+			content: ``,
+			expected: `mapping:
+  "true": .zshrc
+`,
+		},
+	}
+
+	for _, c := range cases {
+		result, err := addYAMLMapping([]byte(c.content), "true", ".zshrc")
+		require.NoError(t, err, c.name)
+		assert.Equal(t, c.expected, string(result), c.name)
+	}
+}
+
+func TestAddYAMLMapping_Duplicate(t *testing.T) {
+	t.Parallel()
+
+	_, err := addYAMLMapping([]byte("mapping:\n  zshrc: .zshrc\n"), "zshrc", ".zshrc")
+
+	assert.Error(t, err)
+}
+
+func TestRemoveYAMLMapping(t *testing.T) {
+	t.Parallel()
+
+	content := `mapping:
+  # Vim
+  vim/vimrc: .vimrc
+  git/config:
+    destination: .gitconfig
+    mode: copy
+`
+
+	result, err := removeYAMLMapping([]byte(content), "git/config")
+
+	require.NoError(t, err)
+	assert.Equal(t, `mapping:
+  # Vim
+  vim/vimrc: .vimrc
+`, string(result))
+
+	_, err = removeYAMLMapping([]byte(content), "zshrc")
+	assert.Error(t, err)
+}
+
+func TestYAMLLine(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`directories:
+  dotfiles: /dotfiles
+mapping:
+  vim/vimrc: .vimrc
+files:
+  excludes:
+    - README.md
+    - LICENSE
+`)
+
+	assert.Equal(t, 1, yamlLine(content, []string{"directories"}, ""))
+	assert.Equal(t, 4, yamlLine(content, []string{"mapping", "vim/vimrc"}, ""))
+	assert.Equal(t, 8, yamlLine(content, []string{"files", "excludes"}, "LICENSE"))
+	assert.Equal(t, 0, yamlLine(content, []string{"mapping", ".vimrc"}, ""))
+}
+
+func TestYAMLUnknownKeys(t *testing.T) {
+	t.Parallel()
+
+	content := []byte(`directories:
+  dotfiles: /dotfiles
+  Destination: /home
+mapping:
+  anything: .anything
+instal:
+  conflict: skip
+`)
+
+	keys, err := yamlUnknownKeys(content)
+
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"directories", "Destination"}, {"instal"}}, keys)
+}
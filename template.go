@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data available to dotfile templates.
+//
+// Example of a template:
+//
+//	[user]
+//	    email = {{ .Vars.email }}
+//	{{- if eq .OS "darwin" }}
+//	[credential]
+//	    helper = osxkeychain
+//	{{- end }}
+type TemplateData struct {
+	Hostname string
+	OS       string
+	Arch     string
+	Username string
+
+	// Env contains environment variables.
+	Env map[string]string
+
+	// Vars contains variables from [variables] section of the profile.
+	Vars map[string]string
+}
+
+// NewTemplateData returns template data for the machine and profile variables.
+func NewTemplateData(m Machine, vars map[string]string) TemplateData {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+
+	return TemplateData{
+		Hostname: m.Hostname,
+		OS:       m.OS,
+		Arch:     m.Arch,
+		Username: m.Username,
+		Env:      env,
+		Vars:     vars,
+	}
+}
+
+// RenderTemplate renders the template file with the data.
+// Referencing a missing variable is an error.
+func RenderTemplate(filename string, data TemplateData) ([]byte, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(filepath.Base(filename)).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"env": os.Getenv}).
+		Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
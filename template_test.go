@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	t.Setenv("DOTBRO_TEST_EDITOR", "vim")
+
+	filename := filepath.Join(t.TempDir(), "gitconfig.tmpl")
+	template := `[user]
+    name = {{ .Username }}
+    email = {{ .Vars.email }}
+[core]
+    editor = {{ env "DOTBRO_TEST_EDITOR" }}
+{{- if eq .OS "darwin" }}
+[credential]
+    helper = osxkeychain
+{{- end }}
+# {{ .Hostname }} {{ .Arch }} {{ .Env.DOTBRO_TEST_EDITOR }}
+`
+	require.NoError(t, os.WriteFile(filename, []byte(template), 0600))
+
+	m := Machine{Hostname: "laptop", OS: "darwin", Arch: "arm64", Username: "jdoe"}
+	content, err := RenderTemplate(filename, NewTemplateData(m, map[string]string{"email": "jdoe@example.com"}))
+
+	require.NoError(t, err)
+	assert.Equal(t, `[user]
+    name = jdoe
+    email = jdoe@example.com
+[core]
+    editor = vim
+[credential]
+    helper = osxkeychain
+# laptop arm64 vim
+`, string(content))
+}
+
+func TestRenderTemplate_MissingVariable(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "gitconfig.tmpl")
+	require.NoError(t, os.WriteFile(filename, []byte("email = {{ .Vars.email }}\n"), 0600))
+
+	_, err := RenderTemplate(filename, NewTemplateData(Machine{}, nil))
+
+	assert.Error(t, err)
+}
+
+func TestRenderTemplate_Invalid(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "gitconfig.tmpl")
+	require.NoError(t, os.WriteFile(filename, []byte("email = {{ .Vars.email \n"), 0600))
+
+	_, err := RenderTemplate(filename, NewTemplateData(Machine{}, nil))
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// stashSuffix is appended to names of files that are moved aside while
+// a transaction is in progress.
+const stashSuffix = ".dotbro-undo"
+
+// Transaction performs plan steps and journals them, so they can be undone.
+//
+// Files and directories removed or replaced by a step are not deleted right away:
+// they are moved aside next to their original location and deleted only when
+// the transaction is committed. Removed symlinks and directories are recreated
+// on rollback.
+type Transaction struct {
+	linker  Linker
+	logger  *slog.Logger
+	journal []journalEntry
+}
+
+// journalEntry describes a performed step and how to undo it.
+type journalEntry struct {
+	step Step
+
+	// stash is the path the file removed or replaced by the step was moved to.
+	stash string
+
+	// target is the target of the symlink removed by the step.
+	target string
+
+	// perm is the permissions of the directory removed by the step.
+	perm os.FileMode
+
+	// created are directories created by the step, the deepest first.
+	created []string
+}
+
+// NewTransaction returns a new Transaction.
+func NewTransaction(linker Linker, logger *slog.Logger) *Transaction {
+	return &Transaction{
+		linker: linker,
+		logger: logger,
+	}
+}
+
+// Do performs the step and journals it.
+func (t *Transaction) Do(ctx context.Context, step Step) error {
+	entry := journalEntry{step: step}
+
+	switch step.Kind {
+	case StepRemoveDeadSymlink, StepRemoveWrongSymlink, StepUnlink, StepRemoveCopy, StepDelete:
+		fi, err := t.linker.os.Lstat(step.Dst)
+		if err != nil {
+			return err
+		}
+
+		// Symlinks are just recreated on undo, so they never get in the way
+		// of removing their directory.
+		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+			if entry.target, err = t.linker.os.Readlink(step.Dst); err != nil {
+				return err
+			}
+			if err = step.execute(ctx, t.linker); err != nil {
+				return err
+			}
+			break
+		}
+
+		if entry.stash, err = t.stash(step.Dst); err != nil {
+			return err
+		}
+	case StepRemoveDir:
+		fi, err := t.linker.os.Lstat(step.Dst)
+		if err != nil {
+			return err
+		}
+		if err = step.execute(ctx, t.linker); err != nil {
+			return err
+		}
+		entry.perm = fi.Mode().Perm()
+	default:
+		created, err := t.missingDirs(path.Dir(step.Dst))
+		if err != nil {
+			return err
+		}
+		entry.created = created
+
+		if step.Kind == StepCopy || step.Kind == StepRender {
+			if _, err = t.linker.os.Lstat(step.Dst); err == nil {
+				if entry.stash, err = t.stash(step.Dst); err != nil {
+					return err
+				}
+			} else if !t.linker.os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if err = step.execute(ctx, t.linker); err != nil {
+			// The step may have done a part of its work.
+			return errors.Join(err, t.undo(entry))
+		}
+	}
+
+	t.journal = append(t.journal, entry)
+	return nil
+}
+
+// Rollback undoes all performed steps in reverse order.
+// It tries to undo every step even if some of them fail.
+func (t *Transaction) Rollback(ctx context.Context) error {
+	var errs []error
+	for i := len(t.journal) - 1; i >= 0; i-- {
+		entry := t.journal[i]
+		if err := t.undo(entry); err != nil {
+			errs = append(errs, fmt.Errorf("undo %s %s: %w", entry.step.Kind, entry.step.Dst, err))
+			continue
+		}
+		t.logger.InfoContext(ctx, "undo "+entry.step.Kind.String(), entry.step.attrs("↶")...)
+	}
+	t.journal = nil
+	return errors.Join(errs...)
+}
+
+// Commit deletes files moved aside by performed steps.
+func (t *Transaction) Commit() error {
+	var errs []error
+	for _, entry := range t.journal {
+		if entry.stash == "" {
+			continue
+		}
+		if err := t.linker.os.RemoveAll(entry.stash); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.journal = nil
+	return errors.Join(errs...)
+}
+
+func (t *Transaction) undo(entry journalEntry) error {
+	step := entry.step
+
+	var err error
+	switch step.Kind {
+	case StepRemoveDeadSymlink, StepRemoveWrongSymlink, StepUnlink, StepRemoveCopy, StepDelete:
+		if entry.stash != "" {
+			err = t.linker.os.Rename(entry.stash, step.Dst)
+		} else {
+			err = t.linker.os.Symlink(entry.target, step.Dst)
+		}
+	case StepRemoveDir:
+		err = t.linker.os.MkdirAll(step.Dst, entry.perm)
+	case StepBackupCopy:
+		err = t.linker.os.RemoveAll(step.Dst)
+	case StepBackup, StepRestore, StepAdopt:
+		if _, statErr := t.linker.os.Lstat(step.Dst); statErr == nil {
+			err = t.linker.os.Rename(step.Dst, step.Src)
+		}
+	default:
+		if removeErr := t.linker.os.Remove(step.Dst); removeErr != nil && !t.linker.os.IsNotExist(removeErr) {
+			err = removeErr
+		} else if entry.stash != "" {
+			err = t.linker.os.Rename(entry.stash, step.Dst)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// A failed step may not have got to create the directories.
+	for _, dir := range entry.created {
+		if err = t.linker.os.Remove(dir); err != nil && !t.linker.os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stash moves the file at p aside and returns its new path.
+func (t *Transaction) stash(p string) (string, error) {
+	stash := p + stashSuffix
+	for i := 2; ; i++ {
+		if _, err := t.linker.os.Lstat(stash); t.linker.os.IsNotExist(err) {
+			break
+		}
+		stash = fmt.Sprintf("%s%s%d", p, stashSuffix, i)
+	}
+
+	if err := t.linker.os.Rename(p, stash); err != nil {
+		return "", err
+	}
+	return stash, nil
+}
+
+// missingDirs returns dir and its ancestors that do not exist, the deepest first.
+func (t *Transaction) missingDirs(dir string) ([]string, error) {
+	var missing []string
+	for dir != "/" && dir != "." {
+		_, err := t.linker.os.Lstat(dir)
+		if err == nil {
+			break
+		}
+		if !t.linker.os.IsNotExist(err) {
+			return nil, err
+		}
+		missing = append(missing, dir)
+		dir = filepath.Dir(dir)
+	}
+	return missing, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransaction_Rollback(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "dotfiles", "vimrc")
+	home := filepath.Join(tmpDir, "home")
+	vimrc := filepath.Join(home, ".vimrc")
+	wrong := filepath.Join(home, ".zshrc")
+	gitconfig := filepath.Join(home, ".gitconfig")
+	backup := filepath.Join(tmpDir, "backup", "run", ".vimrc")
+	nested := filepath.Join(home, ".config", "nvim", "init.lua")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0700))
+	require.NoError(t, os.WriteFile(src, []byte("source"), 0600))
+	require.NoError(t, os.MkdirAll(home, 0700))
+	require.NoError(t, os.WriteFile(vimrc, []byte("original"), 0600))
+	require.NoError(t, os.Symlink("/nowhere", wrong))
+	require.NoError(t, os.WriteFile(gitconfig, []byte("old copy"), 0600))
+
+	tx := NewTransaction(NewLinker(osfs, newDiscardLogger()), newDiscardLogger())
+
+	steps := []Step{
+		{Kind: StepRemoveWrongSymlink, Dst: wrong},
+		{Kind: StepBackup, Src: vimrc, Dst: backup},
+		{Kind: StepSymlink, Src: src, Dst: vimrc},
+		{Kind: StepCopy, Src: src, Dst: gitconfig},
+		{Kind: StepSymlink, Src: src, Dst: nested},
+	}
+	for _, step := range steps {
+		require.NoError(t, tx.Do(t.Context(), step))
+	}
+
+	content, err := os.ReadFile(gitconfig)
+	require.NoError(t, err)
+	assert.Equal(t, "source", string(content))
+
+	require.NoError(t, tx.Rollback(t.Context()))
+
+	content, err = os.ReadFile(vimrc)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+
+	content, err = os.ReadFile(gitconfig)
+	require.NoError(t, err)
+	assert.Equal(t, "old copy", string(content))
+
+	target, err := os.Readlink(wrong)
+	require.NoError(t, err)
+	assert.Equal(t, "/nowhere", target)
+
+	// Directories created by the steps are removed as well.
+	assert.NoDirExists(t, filepath.Join(tmpDir, "backup"))
+	assert.NoDirExists(t, filepath.Join(home, ".config"))
+
+	entries, err := os.ReadDir(home)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestTransaction_Commit(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	dest := filepath.Join(tmpDir, "dest")
+
+	require.NoError(t, os.WriteFile(src, []byte("new"), 0600))
+	require.NoError(t, os.WriteFile(dest, []byte("old"), 0600))
+
+	tx := NewTransaction(NewLinker(osfs, newDiscardLogger()), newDiscardLogger())
+	require.NoError(t, tx.Do(t.Context(), Step{Kind: StepCopy, Src: src, Dst: dest}))
+	assert.FileExists(t, dest+stashSuffix)
+
+	require.NoError(t, tx.Commit())
+
+	assert.NoFileExists(t, dest+stashSuffix)
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+}
+
+func TestTransaction_Rollback_Delete(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	dest := filepath.Join(tmpDir, "nvim")
+	file := filepath.Join(dest, "init.lua")
+
+	require.NoError(t, os.MkdirAll(dest, 0700))
+	require.NoError(t, os.WriteFile(file, []byte("original"), 0600))
+
+	tx := NewTransaction(NewLinker(osfs, newDiscardLogger()), newDiscardLogger())
+	require.NoError(t, tx.Do(t.Context(), Step{Kind: StepDelete, Dst: dest}))
+	assert.NoDirExists(t, dest)
+
+	require.NoError(t, tx.Rollback(t.Context()))
+
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(content))
+}
+
+func TestTransaction_Do_FailedBeforeCreatingDirs(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "dotfiles", "missing")
+	dest := filepath.Join(tmpDir, "home", ".config", "app", "config")
+
+	tx := NewTransaction(NewLinker(osfs, newDiscardLogger()), newDiscardLogger())
+	err := tx.Do(t.Context(), Step{Kind: StepCopy, Src: src, Dst: dest})
+
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "remove", "the directories were never created")
+	assert.NoDirExists(t, filepath.Join(tmpDir, "home"))
+	require.NoError(t, tx.Rollback(t.Context()))
+}